@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written as a string like "15s" in the config.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"15s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Op is an entry of the ops.json written into every server directory.
+type Op struct {
+	UUID                string `json:"uuid"`
+	Name                string `json:"name"`
+	Level               int    `json:"level"`
+	BypassesPlayerLimit bool   `json:"bypassesPlayerLimit"`
+}
+
+// Config is the instance manager configuration, read from a JSON file at
+// startup. The scalar fields and the ops can be overridden by the environment
+// variables listed in envOverrides.
+type Config struct {
+	// address the HTTP API listens on
+	Listen string `json:"listen"`
+	// admin API of the Velocity proxy plugin
+	ProxyAPIURL string `json:"proxy_api_url"`
+	// server manager told about crashes and restarts; empty disables it
+	ServerManagerURL string `json:"server_manager_url"`
+	// server players are moved to while theirs is saved or restarted
+	DefaultFallback string `json:"default_fallback"`
+	// where the world zips are downloaded from and saved to
+	WorldStore WorldStoreConfig `json:"world_store"`
+	// "owner/repo" and branch holding the world zips for the github store
+	WorldsRepo   string `json:"worlds_repo"`
+	WorldsBranch string `json:"worlds_branch"`
+	// how many world snapshots are kept per instance
+	Snapshots SnapshotConfig `json:"snapshots"`
+	// ports handed out to Paper servers, both inclusive
+	PortBase int `json:"port_base"`
+	PortMax  int `json:"port_max"`
+	// where the server directories are, one per instance name
+	Servers ServerDirConfig `json:"servers"`
+	// file remembering which port belongs to which server
+	PortRegistry string `json:"port_registry"`
+	// file the running servers are kept in, to adopt them after a restart
+	InstanceTable string `json:"instance_table"`
+	// Java settings of Paper servers, refined by the templates
+	JVM JVMOptions `json:"jvm"`
+	// what to do when a server exits on its own, refined by the templates
+	Restart RestartPolicy `json:"restart"`
+	// timeouts of the save-all/stop shutdown
+	Stop StopConfig `json:"stop"`
+	// where the console of every instance is written
+	Logs           LogConfig `json:"logs"`
+	VelocitySecret string    `json:"velocity_secret"`
+	Ops            []Op      `json:"ops"`
+	// server types, matched against instance names in order
+	Templates []Template `json:"templates"`
+}
+
+// dataDir holds the server directories, logs, port registry and instance
+// table by default. It is next to the updated tree instead of inside it, as
+// updates and rollbacks replace that tree while servers keep running.
+const dataDir = "../im_data"
+
+func defaultConfig() Config {
+	return Config{
+		Listen:           ":8000",
+		ProxyAPIURL:      "http://172.30.0.1:8081",
+		ServerManagerURL: "http://172.30.0.1:8080",
+		Restart: RestartPolicy{
+			Mode:        RestartOnFailure,
+			Backoff:     Duration(5 * time.Second),
+			MaxBackoff:  Duration(5 * time.Minute),
+			MaxRestarts: 5,
+		},
+		Stop: StopConfig{
+			SaveTimeout: Duration(30 * time.Second),
+			Timeout:     Duration(60 * time.Second),
+		},
+		Logs: LogConfig{
+			Dir:       filepath.Join(dataDir, "logs"),
+			MaxSizeMB: 10,
+			MaxAge:    Duration(24 * time.Hour),
+			MaxFiles:  10,
+		},
+		WorldStore: WorldStoreConfig{
+			Backend: WorldStoreGitHub,
+			S3:      S3Config{Region: "us-east-1"},
+		},
+		Snapshots: SnapshotConfig{
+			KeepLast:   5,
+			KeepDaily:  7,
+			KeepWeekly: 4,
+		},
+		DefaultFallback: "lobby",
+		WorldsRepo:      "JuMaEn16/lunexia-worlds",
+		WorldsBranch:    "main",
+		PortBase:        3000,
+		PortMax:         3999,
+		Servers: ServerDirConfig{
+			Dir:       filepath.Join(dataDir, "servers"),
+			Retention: Duration(7 * 24 * time.Hour),
+		},
+		PortRegistry:  filepath.Join(dataDir, "ports.json"),
+		InstanceTable: filepath.Join(dataDir, "instances.json"),
+		JVM:           JVMOptions{Java: "java", Memory: "2G"},
+		Ops:           []Op{},
+		Templates: []Template{{
+			Name:    "default",
+			Pattern: "*",
+			World:   "{name}.zip",
+			Plugins: "plugins",
+			PluginConfigs: map[string]string{
+				"LunexiaMain/config.yml": "type: \"{name}\"\nsubtype: \"\"",
+			},
+		}},
+	}
+}
+
+// envOverrides maps environment variables to the field they set.
+var envOverrides = []struct {
+	name string
+	set  func(c *Config, v string) error
+}{
+	{"IM_LISTEN", func(c *Config, v string) error { c.Listen = v; return nil }},
+	{"IM_PROXY_API_URL", func(c *Config, v string) error { c.ProxyAPIURL = v; return nil }},
+	{"IM_SERVER_MANAGER_URL", func(c *Config, v string) error { c.ServerManagerURL = v; return nil }},
+	{"IM_RESTART", func(c *Config, v string) error { c.Restart.Mode = v; return nil }},
+	// before the single paths, so those can still be set apart
+	{"IM_DATA_DIR", func(c *Config, v string) error {
+		c.Servers.Dir = filepath.Join(v, "servers")
+		c.Logs.Dir = filepath.Join(v, "logs")
+		c.PortRegistry = filepath.Join(v, "ports.json")
+		c.InstanceTable = filepath.Join(v, "instances.json")
+		return nil
+	}},
+	{"IM_SERVERS_DIR", func(c *Config, v string) error { c.Servers.Dir = v; return nil }},
+	{"IM_LOG_DIR", func(c *Config, v string) error { c.Logs.Dir = v; return nil }},
+	{"IM_DEFAULT_FALLBACK", func(c *Config, v string) error { c.DefaultFallback = v; return nil }},
+	{"IM_WORLDS_REPO", func(c *Config, v string) error { c.WorldsRepo = v; return nil }},
+	{"IM_WORLDS_BRANCH", func(c *Config, v string) error { c.WorldsBranch = v; return nil }},
+	{"IM_WORLD_STORE", func(c *Config, v string) error { c.WorldStore.Backend = v; return nil }},
+	{"IM_WORLD_STORE_DIR", func(c *Config, v string) error { c.WorldStore.Dir = v; return nil }},
+	{"IM_S3_ENDPOINT", func(c *Config, v string) error { c.WorldStore.S3.Endpoint = v; return nil }},
+	{"IM_S3_BUCKET", func(c *Config, v string) error { c.WorldStore.S3.Bucket = v; return nil }},
+	{"IM_S3_ACCESS_KEY", func(c *Config, v string) error { c.WorldStore.S3.AccessKey = v; return nil }},
+	{"IM_S3_SECRET_KEY", func(c *Config, v string) error { c.WorldStore.S3.SecretKey = v; return nil }},
+	{"IM_PORT_BASE", func(c *Config, v string) (err error) { c.PortBase, err = strconv.Atoi(v); return err }},
+	{"IM_PORT_MAX", func(c *Config, v string) (err error) { c.PortMax, err = strconv.Atoi(v); return err }},
+	{"IM_JAVA", func(c *Config, v string) error { c.JVM.Java = v; return nil }},
+	{"IM_MEMORY", func(c *Config, v string) error { c.JVM.Memory = v; return nil }},
+	{"IM_VELOCITY_SECRET", func(c *Config, v string) error { c.VelocitySecret = v; return nil }},
+	{"IM_OPS", func(c *Config, v string) error { c.Ops = nil; return json.Unmarshal([]byte(v), &c.Ops) }},
+}
+
+// Validate reports the first problem with c.
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	u, err := url.Parse(c.ProxyAPIURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("proxy_api_url: %q is not an http(s) URL", c.ProxyAPIURL)
+	}
+	if c.ServerManagerURL != "" {
+		u, err := url.Parse(c.ServerManagerURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("server_manager_url: %q is not an http(s) URL", c.ServerManagerURL)
+		}
+	}
+	if err := c.Restart.validate(); err != nil {
+		return fmt.Errorf("restart: %w", err)
+	}
+	if err := c.Stop.validate(); err != nil {
+		return fmt.Errorf("stop: %w", err)
+	}
+	if err := c.Logs.validate(); err != nil {
+		return fmt.Errorf("logs: %w", err)
+	}
+	if err := c.WorldStore.validate(); err != nil {
+		return fmt.Errorf("world_store: %w", err)
+	}
+	if c.WorldStore.Backend == WorldStoreGitHub {
+		if owner, repo, ok := strings.Cut(c.WorldsRepo, "/"); !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			return fmt.Errorf("worlds_repo: %q is not owner/repo", c.WorldsRepo)
+		}
+		if c.WorldsBranch == "" {
+			return errors.New("worlds_branch is empty")
+		}
+	}
+	if err := c.Snapshots.validate(); err != nil {
+		return fmt.Errorf("snapshots: %w", err)
+	}
+	if c.PortBase < 1024 || c.PortBase > 65535 {
+		return fmt.Errorf("port_base: %d is outside 1024-65535", c.PortBase)
+	}
+	if c.PortMax < c.PortBase || c.PortMax > 65535 {
+		return fmt.Errorf("port_max: %d is outside %d-65535", c.PortMax, c.PortBase)
+	}
+	if err := c.Servers.validate(); err != nil {
+		return fmt.Errorf("servers: %w", err)
+	}
+	if c.PortRegistry == "" || c.InstanceTable == "" {
+		return errors.New("port_registry and instance_table must be set")
+	}
+	if c.JVM.Memory == "" {
+		return errors.New("jvm.memory is empty")
+	}
+	if err := c.JVM.validate(); err != nil {
+		return fmt.Errorf("jvm: %w", err)
+	}
+	if c.VelocitySecret == "" {
+		return errors.New("velocity_secret is empty (set it in the config file or $IM_VELOCITY_SECRET)")
+	}
+	for i, op := range c.Ops {
+		if op.UUID == "" || op.Name == "" {
+			return fmt.Errorf("ops[%d]: uuid and name are required", i)
+		}
+	}
+	if len(c.Templates) == 0 {
+		return errors.New("templates: at least one is required")
+	}
+	seen := make(map[string]bool)
+	for i := range c.Templates {
+		t := &c.Templates[i]
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("templates[%d]: %w", i, err)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("templates: duplicate name %q", t.Name)
+		}
+		seen[t.Name] = true
+	}
+	return nil
+}
+
+// exampleConfigPath is the config shipped in the tree.
+const exampleConfigPath = "instance_manager.example.json"
+
+// seedConfig creates a missing config file at path from the example in the
+// tree. Older versions had the example's settings built in, so an IM updated
+// from one keeps its velocity secret, ops and templates. It reports whether
+// it created the file.
+func seedConfig(path string) (bool, error) {
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	tmp := path + ".tmp"
+	if err := copyFile(exampleConfigPath, tmp); err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("creating %s from %s: %w", path, exampleConfigPath, err)
+	}
+	return true, os.Rename(tmp, path)
+}
+
+// loadConfig reads the config file at path, if it exists, on top of the
+// defaults, applies the environment overrides and validates the result.
+func loadConfig(path string, getenv func(string) string) (*Config, error) {
+	c := defaultConfig()
+	// decoding into the default templates would merge their maps into the
+	// configured ones
+	c.Templates = nil
+	if f, err := os.Open(path); err == nil {
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&c)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if c.Templates == nil {
+		c.Templates = defaultConfig().Templates
+	}
+
+	for _, o := range envOverrides {
+		if v := getenv(o.name); v != "" {
+			if err := o.set(&c, v); err != nil {
+				return nil, fmt.Errorf("$%s: %w", o.name, err)
+			}
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &c, nil
+}
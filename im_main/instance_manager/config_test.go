@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instance_manager.json")
+	if err := os.WriteFile(path, []byte(`{"velocity_secret": "file", "jvm": {"memory": "4G"}, "port_base": 4000, "port_max": 4999}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"IM_MEMORY":          "6G",
+		"IM_VELOCITY_SECRET": "env",
+		"IM_OPS":             `[{"uuid": "u", "name": "n", "level": 4}]`,
+		"IM_DATA_DIR":        "/srv/im",
+		"IM_LOG_DIR":         "/var/log/im",
+	}
+	c, err := loadConfig(path, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.JVM.Memory != "6G" || c.JVM.Java != "java" || c.VelocitySecret != "env" || c.PortBase != 4000 || c.Listen != ":8000" {
+		t.Errorf("config = %+v", c)
+	}
+	if c.Servers.Dir != "/srv/im/servers" || c.PortRegistry != "/srv/im/ports.json" || c.InstanceTable != "/srv/im/instances.json" || c.Logs.Dir != "/var/log/im" {
+		t.Errorf("data paths = %s, %s, %s, %s", c.Servers.Dir, c.PortRegistry, c.InstanceTable, c.Logs.Dir)
+	}
+	if len(c.Ops) != 1 || c.Ops[0].Name != "n" {
+		t.Errorf("ops = %+v", c.Ops)
+	}
+	if c.WorldStore.Backend != WorldStoreGitHub || c.WorldsRepo != "JuMaEn16/lunexia-worlds" || c.WorldsBranch != "main" {
+		t.Errorf("world store = %+v, repo %s@%s", c.WorldStore, c.WorldsRepo, c.WorldsBranch)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	cases := map[string]map[string]string{
+		"no secret":     {},
+		"bad memory":    {"IM_VELOCITY_SECRET": "s", "IM_MEMORY": "2GB"},
+		"bad port base": {"IM_VELOCITY_SECRET": "s", "IM_PORT_BASE": "three"},
+		"low port base": {"IM_VELOCITY_SECRET": "s", "IM_PORT_BASE": "80"},
+		"bad repo":      {"IM_VELOCITY_SECRET": "s", "IM_WORLDS_REPO": "lunexia-worlds"},
+		"bad proxy":     {"IM_VELOCITY_SECRET": "s", "IM_PROXY_API_URL": "172.30.0.1:8081"},
+		"bad restart":   {"IM_VELOCITY_SECRET": "s", "IM_RESTART": "sometimes"},
+		"bad store":     {"IM_VELOCITY_SECRET": "s", "IM_WORLD_STORE": "ftp"},
+		"s3 no bucket":  {"IM_VELOCITY_SECRET": "s", "IM_WORLD_STORE": "s3", "IM_S3_ENDPOINT": "http://minio:9000"},
+	}
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	for name, env := range cases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := loadConfig(missing, func(k string) string { return env[k] }); err == nil {
+				t.Fatal("loadConfig succeeded")
+			}
+		})
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	c, err := loadConfig("instance_manager.example.json", func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name, template, world, fallback string
+	}{
+		{"lobby", "lobby", "lobby.zip", ""},
+		{"lunaris_asteroid_bob", "lunaris_asteroid", "lunaris_asteroid/lunaris_asteroid_bob.zip", "lunaris_asteroid.zip"},
+		{"lunaris", "lunaris", "lunaris.zip", ""},
+		{"event", "default", "event.zip", ""},
+	}
+	for _, tc := range cases {
+		tmpl, err := c.template(tc.name)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if tmpl.Name != tc.template || tmpl.WorldFile(tc.name) != tc.world || tmpl.FallbackWorldFile(tc.name) != tc.fallback {
+			t.Errorf("%s: template %s, world %s, fallback %q", tc.name, tmpl.Name, tmpl.WorldFile(tc.name), tmpl.FallbackWorldFile(tc.name))
+		}
+	}
+}
+
+func TestTemplateServerProperties(t *testing.T) {
+	tmpl := Template{Properties: map[string]string{"online-mode": "true", "max-players": "20"}}
+	want := "server-port=3001\nenable-command-block=true\nmax-players=20\nmotd=Dynamic Paper Server 3001\nonline-mode=true\n"
+	if got := tmpl.serverProperties(3001); got != want {
+		t.Errorf("serverProperties =\n%s\nwant\n%s", got, want)
+	}
+
+	bad := Template{Name: "x", Pattern: "x", World: "x.zip", Plugins: "plugins", PluginConfigs: map[string]string{"../evil.yml": ""}}
+	if err := bad.validate(); err == nil {
+		t.Error("plugin config outside the plugins folder was accepted")
+	}
+}
+
+func TestJVMArgs(t *testing.T) {
+	global := JVMOptions{Java: "java", Memory: "2G", Flags: []string{"-XX:+UseG1GC"}, Properties: map[string]string{"a": "1"}}
+	o := global.merge(JVMOptions{Memory: "6G", Properties: map[string]string{"b": "2"}})
+	want := "java -Xms6G -Xmx6G -XX:+UseG1GC -Da=1 -Db=2 -jar paper.jar --nogui"
+	if got := strings.Join(o.args(), " "); got != want {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+	if len(global.Properties) != 1 {
+		t.Error("merge modified the global properties")
+	}
+
+	for s, want := range map[string]uint64{"512M": 512 << 20, "2G": 2 << 30, "2g": 2 << 30, "1024": 1024} {
+		if got, err := parseMemory(s); err != nil || got != want {
+			t.Errorf("parseMemory(%q) = %d, %v; want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "2GB", "0G", "-1G", "99999999999999999999G"} {
+		if _, err := parseMemory(s); err == nil {
+			t.Errorf("parseMemory(%q) succeeded", s)
+		}
+	}
+}
+
+func TestSeedConfig(t *testing.T) {
+	example, err := os.ReadFile(exampleConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an updated tree, and no config next to it yet
+	root := t.TempDir()
+	tree := filepath.Join(root, "instance_manager")
+	if err := os.MkdirAll(tree, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tree, exampleConfigPath), example, 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(tree)
+
+	if seeded, err := seedConfig("../instance_manager.json"); err != nil || !seeded {
+		t.Fatalf("seedConfig = %v, %v; want the example copied", seeded, err)
+	}
+	c, err := loadConfig("../instance_manager.json", func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.VelocitySecret == "" || len(c.Ops) == 0 || len(c.Templates) < 2 {
+		t.Errorf("seeded config lacks the built-in settings: secret %q, %d ops, %d templates", c.VelocitySecret, len(c.Ops), len(c.Templates))
+	}
+
+	// an existing config is left alone
+	if err := os.WriteFile("../instance_manager.json", []byte(`{"velocity_secret": "mine"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if seeded, err := seedConfig("../instance_manager.json"); err != nil || seeded {
+		t.Errorf("seedConfig over a config = %v, %v", seeded, err)
+	}
+	if b, _ := os.ReadFile("../instance_manager.json"); string(b) != `{"velocity_secret": "mine"}` {
+		t.Errorf("config overwritten: %s", b)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// consoleLines is how many lines of console output are kept per server.
+	consoleLines = 500
+	// subscriberBuffer is how many lines a console stream may fall behind
+	// before lines are dropped for it.
+	subscriberBuffer = 256
+	// keepAliveInterval is how often an idle console stream sends a comment
+	// so proxies don't close it.
+	keepAliveInterval = 30 * time.Second
+	// logRetryDelay is how long a server's console log isn't opened again
+	// after opening it failed, e.g. on a full disk.
+	logRetryDelay = time.Minute
+)
+
+// lineRing keeps the last lines written to it and passes new lines on to its
+// subscribers until it is closed.
+type lineRing struct {
+	mu     sync.Mutex
+	lines  []string
+	next   int
+	full   bool
+	subs   map[chan string]struct{}
+	closed bool
+}
+
+func newLineRing(size int) *lineRing {
+	return &lineRing{lines: make([]string, size), subs: make(map[chan string]struct{})}
+}
+
+func (r *lineRing) add(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	r.full = r.full || r.next == 0
+	for ch := range r.subs {
+		select {
+		case ch <- line:
+		default: // a slow reader loses lines rather than blocking the server
+		}
+	}
+}
+
+// subscribe returns up to n of the most recent lines and a channel receiving
+// the lines added after them. The channel is closed by cancel or when r is
+// closed.
+func (r *lineRing) subscribe(n int) (backlog []string, lines <-chan string, cancel func()) {
+	ch := make(chan string, subscriberBuffer)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	backlog = r.lastLocked(n)
+	if r.closed {
+		close(ch)
+		return backlog, ch, func() {}
+	}
+	r.subs[ch] = struct{}{}
+	return backlog, ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if _, ok := r.subs[ch]; ok {
+			delete(r.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// close ends all subscriptions; the lines stay readable.
+func (r *lineRing) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for ch := range r.subs {
+		close(ch)
+	}
+	r.subs = make(map[chan string]struct{})
+	r.closed = true
+}
+
+// last returns up to n of the most recent lines, oldest first.
+func (r *lineRing) last(n int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastLocked(n)
+}
+
+func (r *lineRing) lastLocked(n int) []string {
+	count := r.next
+	if r.full {
+		count = len(r.lines)
+	}
+	n = min(n, count)
+	out := make([]string, 0, n)
+	for i := r.next - n; i < r.next; i++ {
+		out = append(out, r.lines[(i+len(r.lines))%len(r.lines)])
+	}
+	return out
+}
+
+// consoleRing returns the console output of srv, creating it on first use.
+func (srv *Server) consoleRing() *lineRing {
+	mu.Lock()
+	defer mu.Unlock()
+	if srv.console == nil {
+		srv.console = newLineRing(consoleLines)
+	}
+	return srv.console
+}
+
+// output records a line of console output of srv: on the IM's stdout, in
+// the console ring and in the instance log, which is opened on first use.
+// If that fails, the lines are not logged until logRetryDelay has passed.
+func (srv *Server) output(line string) {
+	fmt.Printf("[%s] %s\n", srv.Name, line)
+	srv.consoleRing().add(line)
+
+	mu.Lock()
+	if srv.logFile == nil && !time.Now().Before(srv.logRetry) {
+		l, err := openInstanceLog(srv.Name)
+		if err != nil {
+			log.Printf("Failed to open log of '%s', retrying in %s: %v", srv.Name, logRetryDelay, err)
+			srv.logRetry = time.Now().Add(logRetryDelay)
+		}
+		srv.logFile = l
+	}
+	logFile := srv.logFile
+	mu.Unlock()
+	logFile.writeLine(line)
+}
+
+// sendCommand writes command to the console of the running process of srv.
+func sendCommand(srv *Server, command string) error {
+	mu.Lock()
+	stdin := srv.stdin
+	mu.Unlock()
+	if stdin == nil {
+		return fmt.Errorf("server '%s' has no console input", srv.Name)
+	}
+	// echoed before writing so it precedes the server's response
+	srv.output("> " + command)
+	if _, err := io.WriteString(stdin, command+"\n"); err != nil {
+		return fmt.Errorf("failed to write to the console of '%s': %w", srv.Name, err)
+	}
+	return nil
+}
+
+// findServer returns the server registered under name, or writes a 404 and
+// returns nil.
+func findServer(w http.ResponseWriter, name string) *Server {
+	if name == "" {
+		http.Error(w, "Missing 'name' query parameter", http.StatusBadRequest)
+		return nil
+	}
+	mu.Lock()
+	srv, exists := serverMap[name]
+	mu.Unlock()
+	if !exists {
+		http.Error(w, fmt.Sprintf("Server '%s' not found", name), http.StatusNotFound)
+		return nil
+	}
+	return srv
+}
+
+// consoleHandler streams the console of a server as server-sent events: the
+// last ?lines= lines (default 100), then every new line until the client
+// disconnects or the server is removed.
+func consoleHandler(w http.ResponseWriter, r *http.Request) {
+	srv := findServer(w, r.URL.Query().Get("name"))
+	if srv == nil {
+		return
+	}
+	n := 100
+	if v := r.URL.Query().Get("lines"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			http.Error(w, "Invalid 'lines' query parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	backlog, lines, cancel := srv.consoleRing().subscribe(n)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	for _, line := range backlog {
+		fmt.Fprintf(w, "data: %s\n\n", line)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				fmt.Fprint(w, "event: end\ndata: server removed\n\n")
+				flusher.Flush()
+				return
+			}
+			fmt.Fprintf(w, "data: %s\n\n", line)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// commandHandler runs the console command in the JSON body
+// {"command": "say hi"} on a running or starting server.
+func commandHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	srv := findServer(w, r.URL.Query().Get("name"))
+	if srv == nil {
+		return
+	}
+	var req struct {
+		Command string `json:"command"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	command := strings.TrimPrefix(strings.TrimSpace(req.Command), "/")
+	if command == "" || strings.ContainsAny(command, "\r\n") {
+		http.Error(w, "Command must be a single non-empty line", http.StatusBadRequest)
+		return
+	}
+	if st := srv.state(); st != StateRunning && st != StateStarting {
+		http.Error(w, fmt.Sprintf("Server '%s' is %s", srv.Name, st), http.StatusConflict)
+		return
+	}
+
+	if err := sendCommand(srv, command); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(fmt.Sprintf("Command sent to '%s'", srv.Name)))
+}
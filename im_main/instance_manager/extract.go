@@ -0,0 +1,118 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errUnsafeArchive = errors.New("unsafe archive")
+
+// Limits on what a single zip may unpack to. Worlds are the largest archives
+// we handle, so these are generous; they only stop zip bombs.
+var (
+	maxExtractSize  int64 = 16 << 30
+	maxExtractFiles       = 500000
+)
+
+// extractZip extracts every entry of zr whose name is prefix or lies below it
+// into dest, with prefix stripped (an empty prefix extracts everything). It
+// rejects absolute names, ".." segments and symlinks, and enforces
+// maxExtractSize and maxExtractFiles. It returns the number of extracted
+// entries.
+func extractZip(zr *zip.Reader, prefix, dest string) (int, error) {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	var (
+		extracted int
+		written   int64
+	)
+	for _, f := range zr.File {
+		rel := f.Name
+		if prefix != "" {
+			if strings.TrimSuffix(rel, "/") == prefix {
+				rel = ""
+			} else if strings.HasPrefix(rel, prefix+"/") {
+				rel = strings.TrimPrefix(rel, prefix+"/")
+			} else {
+				continue
+			}
+		}
+
+		outPath, err := safeJoin(dest, rel)
+		if err != nil {
+			return extracted, err
+		}
+		extracted++
+		if extracted > maxExtractFiles {
+			return extracted, fmt.Errorf("%w: more than %d entries", errUnsafeArchive, maxExtractFiles)
+		}
+
+		mode := f.Mode()
+		if mode&os.ModeSymlink != 0 {
+			return extracted, fmt.Errorf("%w: %q is a symlink", errUnsafeArchive, f.Name)
+		}
+		if mode.IsDir() {
+			if err := os.MkdirAll(outPath, 0755); err != nil {
+				return extracted, err
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+			return extracted, err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return extracted, err
+		}
+		// owner always gets read/write, the execute bit is preserved
+		out, err := os.OpenFile(outPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+		if err != nil {
+			rc.Close()
+			return extracted, err
+		}
+		// don't trust the size in the header, count what is actually written
+		n, err := io.Copy(out, io.LimitReader(rc, maxExtractSize-written+1))
+		rc.Close()
+		out.Close()
+		written += n
+		if err != nil {
+			return extracted, err
+		}
+		if written > maxExtractSize {
+			return extracted, fmt.Errorf("%w: uncompressed size exceeds %d bytes", errUnsafeArchive, maxExtractSize)
+		}
+	}
+	return extracted, nil
+}
+
+// safeJoin joins the archive entry name rel onto dest and makes sure the
+// result can't end up outside of dest, neither through the name itself nor
+// through a symlink that already exists below dest.
+func safeJoin(dest, rel string) (string, error) {
+	if rel == "" {
+		return dest, nil
+	}
+	if strings.Contains(rel, `\`) || filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" {
+		return "", fmt.Errorf("%w: %q is not a relative path", errUnsafeArchive, rel)
+	}
+	for _, seg := range strings.Split(rel, "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("%w: %q leaves the destination", errUnsafeArchive, rel)
+		}
+	}
+
+	p := dest
+	for _, seg := range strings.Split(filepath.Clean(rel), string(filepath.Separator)) {
+		p = filepath.Join(p, seg)
+		if fi, err := os.Lstat(p); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w: %q goes through a symlink", errUnsafeArchive, rel)
+		}
+	}
+	return p, nil
+}
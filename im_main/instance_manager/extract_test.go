@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type zipEntry struct {
+	name string
+	body string
+	mode os.FileMode
+}
+
+func makeZip(t *testing.T, entries []zipEntry) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		mode := e.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		hdr.SetMode(mode)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestExtractZipPrefix(t *testing.T) {
+	zr := makeZip(t, []zipEntry{
+		{name: "owner-repo-abc/README.md", body: "ignored"},
+		{name: "owner-repo-abc/im_main/instance_manager/plugins/", mode: os.ModeDir | 0755},
+		{name: "owner-repo-abc/im_main/instance_manager/plugins/a.jar", body: "jar"},
+		{name: "owner-repo-abc/im_main/instance_manager/plugins/sub/b.yml", body: "yml"},
+	})
+	dest := filepath.Join(t.TempDir(), "plugins")
+
+	n, err := extractZip(zr, "owner-repo-abc/im_main/instance_manager/plugins", dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("extracted %d entries, want 3", n)
+	}
+	for name, want := range map[string]string{"a.jar": "jar", "sub/b.yml": "yml"} {
+		got, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestExtractZipRejectsMaliciousEntries(t *testing.T) {
+	cases := map[string][]zipEntry{
+		"dot-dot":        {{name: "../evil", body: "x"}},
+		"nested dot-dot": {{name: "world/../../evil", body: "x"}},
+		"absolute":       {{name: "/tmp/evil", body: "x"}},
+		"backslash":      {{name: `..\evil`, body: "x"}},
+		"symlink":        {{name: "link", body: "..", mode: os.ModeSymlink | 0777}},
+		"prefixed dot-dot": {
+			{name: "repo/plugins/../../../evil", body: "x"},
+		},
+	}
+	for name, entries := range cases {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			dest := filepath.Join(root, "a", "b")
+			prefix := ""
+			if name == "prefixed dot-dot" {
+				prefix = "repo/plugins"
+			}
+			_, err := extractZip(makeZip(t, entries), prefix, dest)
+			if !errors.Is(err, errUnsafeArchive) {
+				t.Fatalf("err = %v, want errUnsafeArchive", err)
+			}
+			if _, err := os.Stat(filepath.Join(root, "evil")); err == nil {
+				t.Error("entry was written outside of the destination")
+			}
+		})
+	}
+}
+
+func TestExtractZipRejectsExistingSymlink(t *testing.T) {
+	root := t.TempDir()
+	dest := filepath.Join(root, "world")
+	outside := filepath.Join(root, "outside")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dest, "region")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := extractZip(makeZip(t, []zipEntry{{name: "region/r.0.0.mca", body: "x"}}), "", dest)
+	if !errors.Is(err, errUnsafeArchive) {
+		t.Fatalf("err = %v, want errUnsafeArchive", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "r.0.0.mca")); err == nil {
+		t.Error("entry was written through the symlink")
+	}
+}
+
+func TestExtractZipLimits(t *testing.T) {
+	defer func(size int64, files int) { maxExtractSize, maxExtractFiles = size, files }(maxExtractSize, maxExtractFiles)
+	maxExtractSize, maxExtractFiles = 1024, 3
+
+	cases := map[string][]zipEntry{
+		"too large":      {{name: "big", body: strings.Repeat("0", 2048)}},
+		"too many files": {{name: "a"}, {name: "b"}, {name: "c"}, {name: "d"}},
+	}
+	for name, entries := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := extractZip(makeZip(t, entries), "", t.TempDir())
+			if !errors.Is(err, errUnsafeArchive) {
+				t.Fatalf("err = %v, want errUnsafeArchive", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// State is the lifecycle state of a server, as reported by /system.
+type State string
+
+const (
+	// StateCreating: the directory and world are being set up.
+	StateCreating State = "creating"
+	// StateStarting: the process was launched and hasn't printed "Done" yet.
+	StateStarting State = "starting"
+	StateRunning  State = "running"
+	// StateStopping: the process is shutting down; once stopped, the server
+	// is removed. If it can't be stopped, it is marked crashed so that it can
+	// be stopped again.
+	StateStopping State = "stopping"
+	// StateSaving: the process is stopped so its world can be uploaded.
+	StateSaving State = "saving"
+	// StateRestarting: the process is stopped and launched again.
+	StateRestarting State = "restarting"
+	StateStopped    State = "stopped"
+	// StateCrashed: the process exited or failed to start unexpectedly.
+	StateCrashed State = "crashed"
+)
+
+// transitions lists the states each state may change to.
+var transitions = map[State][]State{
+	StateCreating:   {StateStarting, StateCrashed},
+	StateStarting:   {StateRunning, StateCrashed},
+	StateRunning:    {StateStopping, StateSaving, StateRestarting, StateCrashed},
+	StateStopping:   {StateStopped, StateCrashed},
+	StateSaving:     {StateRestarting, StateCrashed},
+	StateRestarting: {StateRunning, StateCrashed},
+	StateStopped:    {},
+	StateCrashed:    {StateRestarting, StateStopping},
+}
+
+// startTimeout is how long a server may take to print "Done".
+const startTimeout = 60 * time.Second
+
+var errInvalidTransition = errors.New("invalid state transition")
+
+// Server is a Paper server managed by this IM, registered in serverMap
+// under its name. Status, Port and the crash fields are protected by mu.
+type Server struct {
+	Name string
+	Port int
+	Dir  string
+	// the Paper process, started by launch or adopted at startup
+	Process *os.Process
+	Status  State
+	JVM     JVMOptions // kept for restarts
+
+	// exit code and last console lines of the most recent crash
+	ExitCode *int
+	LastLog  []string
+
+	// the job that created the server; nil for servers in tests
+	job *Job
+	// closed when the process of Cmd has exited
+	exited chan struct{}
+	// console input of the process of Cmd
+	stdin io.WriteCloser
+	// recent console output, kept across restarts
+	console *lineRing
+	// console output on disk; nil if it couldn't be opened, in which case
+	// it isn't tried again before logRetry
+	logFile  *instanceLog
+	logRetry time.Time
+	// when the current process finished starting
+	startedAt time.Time
+	// automatic restarts since the server last ran stably
+	restarts int
+}
+
+// setState moves srv to state to, failing if the lifecycle doesn't allow it.
+func (srv *Server) setState(to State) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return srv.setStateLocked(to)
+}
+
+func (srv *Server) setStateLocked(to State) error {
+	from := srv.Status
+	if !slices.Contains(transitions[from], to) {
+		return fmt.Errorf("%w: server '%s' is %s, can't become %s", errInvalidTransition, srv.Name, from, to)
+	}
+	srv.Status = to
+	log.Printf("Server '%s': %s -> %s", srv.Name, from, to)
+	if serverMap[srv.Name] == srv {
+		saveInstanceTableLocked()
+	}
+	return nil
+}
+
+// state returns the current state of srv.
+func (srv *Server) state() State {
+	mu.Lock()
+	defer mu.Unlock()
+	return srv.Status
+}
+
+// launch starts the Paper process of srv in srv.Dir and waits until it has
+// printed its "Done" line. The process is killed if that doesn't happen within
+// startTimeout. It doesn't change the state of srv.
+func launch(srv *Server) error {
+	cmd, err := javaCommand(srv.Dir, srv.JVM)
+	if err != nil {
+		return err
+	}
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	cmd.Stderr = cmd.Stdout
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+	srv.consoleRing()
+	exited := make(chan struct{})
+	mu.Lock()
+	srv.Process = cmd.Process
+	srv.exited = exited
+	srv.stdin = stdin
+	// restarts aren't tracked by the job of the first start
+	job := srv.job
+	if srv.Status != StateStarting {
+		job = nil
+	}
+	mu.Unlock()
+	job.setPhase(PhaseWaitingForDone)
+
+	// read the output until the process closes it, then reap the process; an
+	// exit while the server is running is a crash
+	done := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(stdoutPipe)
+		seenDone := false
+		for scanner.Scan() {
+			line := scanner.Text()
+			srv.output(line)
+
+			// match typical Paper/Bukkit done message
+			if !seenDone && strings.Contains(line, "Done") && strings.Contains(line, "For help") {
+				seenDone = true
+				close(done)
+			}
+		}
+		err := cmd.Wait()
+		log.Printf("Server '%s' process exited: %v", srv.Name, err)
+		close(exited)
+		if srv.state() == StateRunning {
+			crashHandlers.Add(1)
+			go handleCrash(srv, cmd.Process, cmd.ProcessState.ExitCode())
+		}
+	}()
+
+	select {
+	case <-done:
+		mu.Lock()
+		srv.startedAt = time.Now()
+		mu.Unlock()
+		return nil
+	case <-exited:
+		return fmt.Errorf("server '%s' exited before it finished starting", srv.Name)
+	case <-time.After(startTimeout):
+		_ = cmd.Process.Kill()
+		<-exited
+		return fmt.Errorf("server '%s' start timed out after %s", srv.Name, startTimeout)
+	}
+}
+
+// StopOutcome is how a server process ended, as reported by /stop-server.
+type StopOutcome string
+
+const (
+	// StopGraceful: the process exited after the "stop" command.
+	StopGraceful StopOutcome = "stopped"
+	// StopKilled: the process didn't exit within stop.timeout and was killed.
+	StopKilled StopOutcome = "killed"
+)
+
+// Console lines Paper prints while saving and shutting down.
+const (
+	markerSaved        = "Saved the game"
+	markerStopping     = "Stopping server"
+	markerSavingChunks = "Saving chunks"
+)
+
+// StopConfig holds the timeouts of a graceful shutdown.
+type StopConfig struct {
+	// wait for "Saved the game" after save-all
+	SaveTimeout Duration `json:"save_timeout"`
+	// wait for the process to exit after stop before killing it
+	Timeout Duration `json:"timeout"`
+}
+
+func (c *StopConfig) validate() error {
+	if c.SaveTimeout <= 0 || c.Timeout <= 0 {
+		return errors.New("save_timeout and timeout must be positive")
+	}
+	return nil
+}
+
+// StopResult describes a finished shutdown.
+type StopResult struct {
+	Outcome StopOutcome `json:"outcome"`
+	// Paper confirmed that the world was written to disk
+	Saved bool `json:"saved"`
+}
+
+// stopProcess shuts the process of srv down: it sends save-all and waits for
+// the save to be confirmed, then sends stop and waits for the process to exit.
+// A process that doesn't exit within cfg.Stop.Timeout is killed. It doesn't
+// change the state of srv.
+func stopProcess(srv *Server) (StopResult, error) {
+	mu.Lock()
+	proc, exited := srv.Process, srv.exited
+	mu.Unlock()
+	if proc == nil {
+		return StopResult{Outcome: StopGraceful}, nil
+	}
+	select {
+	case <-exited:
+		return StopResult{Outcome: StopGraceful}, nil
+	default:
+	}
+
+	_, lines, cancel := srv.consoleRing().subscribe(0)
+	defer cancel()
+	var result StopResult
+
+	if err := sendCommand(srv, "save-all"); err != nil {
+		log.Printf("Server '%s': %v", srv.Name, err)
+	} else {
+		result.Saved = waitForLine(lines, exited, time.Duration(cfg.Stop.SaveTimeout), markerSaved) != ""
+		if !result.Saved {
+			log.Printf("Server '%s' did not confirm save-all within %s", srv.Name, time.Duration(cfg.Stop.SaveTimeout))
+		}
+	}
+
+	select {
+	case <-exited:
+		result.Outcome = StopGraceful
+		return result, nil
+	default:
+	}
+	if err := sendCommand(srv, "stop"); err != nil {
+		// the console is gone; fall back to the JVM's shutdown hook
+		log.Printf("Server '%s': %v, sending SIGINT", srv.Name, err)
+		if err := proc.Signal(syscall.SIGINT); err != nil {
+			return result, fmt.Errorf("failed to signal server: %w", err)
+		}
+	}
+
+	deadline := time.After(time.Duration(cfg.Stop.Timeout))
+	for stopping := true; stopping; {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				lines = nil
+			} else if strings.Contains(line, markerSavingChunks) {
+				result.Saved = true
+			} else if strings.Contains(line, markerStopping) {
+				log.Printf("Server '%s' is stopping.", srv.Name)
+			}
+		case <-exited:
+			stopping = false
+			result.Outcome = StopGraceful
+		case <-deadline:
+			log.Printf("Server '%s' did not stop in %s, killing...", srv.Name, time.Duration(cfg.Stop.Timeout))
+			_ = proc.Kill()
+			<-exited
+			stopping = false
+			result.Outcome = StopKilled
+		}
+	}
+	log.Printf("Server '%s' process %s (world saved: %t).", srv.Name, result.Outcome, result.Saved)
+	return result, nil
+}
+
+// waitForLine returns the first line from lines containing marker, or ""
+// if the process exits or timeout passes first.
+func waitForLine(lines <-chan string, exited <-chan struct{}, timeout time.Duration, marker string) string {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return ""
+			}
+			if strings.Contains(line, marker) {
+				return line
+			}
+		case <-exited:
+			return ""
+		case <-timer.C:
+			return ""
+		}
+	}
+}
+
+// relaunch copies the template's plugins into the server directory again and
+// launches the stopped process of srv, which must be restarting. The server
+// ends up running, or crashed if it doesn't come up.
+func relaunch(srv *Server) error {
+	tmpl, err := cfg.template(srv.Name)
+	if err == nil {
+		err = copyDir(tmpl.Plugins, filepath.Join(srv.Dir, "plugins"))
+	}
+	if err == nil {
+		err = launch(srv)
+	}
+	if err != nil {
+		_ = srv.setState(StateCrashed)
+		return err
+	}
+	return srv.setState(StateRunning)
+}
+
+// removeServer unregisters srv and returns its port to the pool.
+func removeServer(srv *Server) {
+	mu.Lock()
+	if serverMap[srv.Name] == srv {
+		delete(serverMap, srv.Name)
+		saveInstanceTableLocked()
+	}
+	port, dir := srv.Port, srv.Dir
+	mu.Unlock()
+	ports.release(port)
+	touchServerDir(dir)
+	srv.consoleRing().close()
+	mu.Lock()
+	srv.logFile.close()
+	mu.Unlock()
+}
@@ -2,24 +2,19 @@ package main
 
 import (
 	"archive/zip"
-	"bufio"
-	"bytes"
-	"container/heap"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -27,46 +22,12 @@ import (
 	"github.com/shirou/gopsutil/v3/mem"
 )
 
-type Server struct {
-	ID          int
-	Port        int
-	Cmd         *exec.Cmd
-	Status      string
-	cleanupOnce sync.Once
-}
-
-var (
-	servers    = make(map[int]*Server) // protected by serversMux
-	serversMux sync.Mutex
-	serverMap  = make(map[string]*Server) // name -> *Server, protected by mu
-	mu         sync.Mutex
-	nextPort   = 3000       // start from whatever base you want
-	available  = &IntHeap{} // min-heap of freed ports
-)
-
-const (
-	proxyApiHost    = "http://172.30.0.1:8081"
-	defaultFallback = "lobby"
-	repoWorlds      = "JuMaEn16/lunexia-worlds"
-)
-
 var (
-	token = ""
+	serverMap = make(map[string]*Server) // name -> *Server, protected by mu
+	mu        sync.Mutex
 )
 
-type IntHeap []int
-
-func (h IntHeap) Len() int            { return len(h) }
-func (h IntHeap) Less(i, j int) bool  { return h[i] < h[j] } // min-heap
-func (h IntHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
-func (h *IntHeap) Push(x interface{}) { *h = append(*h, x.(int)) }
-func (h *IntHeap) Pop() interface{} {
-	old := *h
-	n := len(old)
-	x := old[n-1]
-	*h = old[:n-1]
-	return x
-}
+var cfg *Config
 
 type Instance struct {
 	Name        string   `json:"name"`
@@ -75,13 +36,21 @@ type Instance struct {
 	TPS         int8     `json:"tps"`
 	Port        int      `json:"port"`
 	Status      string   `json:"status"`
+	Template    string   `json:"template,omitempty"`
+	SaveOnStop  bool     `json:"save_on_stop,omitempty"`
+	Persistent  bool     `json:"persistent,omitempty"`
+	// set once the server has crashed
+	ExitCode *int     `json:"exit_code,omitempty"`
+	Restarts int      `json:"restarts,omitempty"`
+	LastLog  []string `json:"last_log,omitempty"`
 }
 
 type SystemInfo struct {
-	CPUPercent float64    `json:"cpu_percent,omitempty"`
-	RAMUsedMB  uint64     `json:"ram_used_mb,omitempty"`
-	RAMTotalMB uint64     `json:"ram_total_mb,omitempty"`
-	Instances  []Instance `json:"instances,omitempty"`
+	CPUPercent float64        `json:"cpu_percent,omitempty"`
+	RAMUsedMB  uint64         `json:"ram_used_mb,omitempty"`
+	RAMTotalMB uint64         `json:"ram_total_mb,omitempty"`
+	Instances  []Instance     `json:"instances,omitempty"`
+	Templates  []TemplateInfo `json:"templates,omitempty"`
 }
 
 func systemHandler(w http.ResponseWriter, r *http.Request) {
@@ -99,40 +68,42 @@ func systemHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Track running servers by name and include their ports and status
+	// Track servers by name and include their ports and lifecycle state
 	mu.Lock()
 	var instances []Instance
 	for name, s := range serverMap {
-		var (
-			port   int
-			status string
-		)
-
-		// If 's' is not nil, the server instance exists.
-		// If 's' is nil, it might be an entry for a server that is stopped.
-		if s != nil {
-			port = int(s.Port)
-			// We get the status directly from the server struct
-			status = s.Status // <-- READ STATUS
-		} else {
-			// If s is nil, we can consider it "stopped"
-			status = "running"
-		}
-
-		instances = append(instances, Instance{
+		inst := Instance{
 			Name:   name,
-			Port:   port,
-			Status: status, // <-- ASSIGN STATUS
-		})
+			Port:   s.Port,
+			Status: string(s.Status),
+			// ExitCode and LastLog are replaced, never modified
+			ExitCode: s.ExitCode,
+			Restarts: s.restarts,
+		}
+		if s.Status == StateCrashed {
+			inst.LastLog = s.LastLog
+		}
+		if t, err := cfg.template(name); err == nil {
+			inst.Template = t.Name
+			inst.SaveOnStop = t.SaveOnStop
+			inst.Persistent = t.Persistent
+		}
+		instances = append(instances, inst)
 	}
 	mu.Unlock()
 
+	templates := make([]TemplateInfo, 0, len(cfg.Templates))
+	for i := range cfg.Templates {
+		templates = append(templates, cfg.Templates[i].info())
+	}
+
 	// Create the final response struct
 	sysInfo := SystemInfo{
 		CPUPercent: cpuPercent[0], // cpu.Percent returns a slice, take the first element
 		RAMUsedMB:  vmStat.Used / 1024 / 1024,
 		RAMTotalMB: vmStat.Total / 1024 / 1024,
 		Instances:  instances,
+		Templates:  templates,
 	}
 
 	// Encode and send the JSON response
@@ -143,7 +114,11 @@ func systemHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func setupServerDir(dir string, port int, name string) error {
+// setupServerDir writes everything the server name needs into dir and
+// installs its world, reporting its progress to job.
+func setupServerDir(dir string, port int, name string, t *Template, job *Job) error {
+	job.setPhase(PhasePreparing)
+
 	// Create server directory
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
@@ -155,13 +130,7 @@ func setupServerDir(dir string, port int, name string) error {
 	}
 
 	// Write updated server.properties
-	props := fmt.Sprintf(
-		`server-port=%d
-motd=Dynamic Paper Server %d
-enable-command-block=true
-online-mode=false
-`, port, port)
-
+	props := t.serverProperties(port)
 	if err := os.WriteFile(filepath.Join(dir, "server.properties"), []byte(props), 0644); err != nil {
 		return err
 	}
@@ -173,48 +142,25 @@ online-mode=false
 	}
 
 	// Write paper-global.yaml
-	paperGlobal := `proxies:
+	paperGlobal := fmt.Sprintf(`proxies:
   bungee-cord:
     online-mode: true
   proxy-protocol: false
   velocity:
     enabled: true
     online-mode: true
-    secret: qJQe07fSMCfn
-`
+    secret: %q
+`, cfg.VelocitySecret)
 	if err := os.WriteFile(filepath.Join(configDir, "paper-global.yml"), []byte(paperGlobal), 0644); err != nil {
 		return err
 	}
 
 	// Write ops.json
-	ops := `
-[
-    {
-        "uuid": "ff642073-9c37-4956-8404-7d10fabaf254",
-        "name": "Ang2l",
-        "level": 4,
-        "bypassesPlayerLimit": false
-    },
-	{
-		"uuid": "d60196b5-b291-41d0-913e-20e19bf502fb",
-        "name": "einMitsuki",
-        "level": 4,
-        "bypassesPlayerLimit": false
-	},
-	{
-		"uuid": "5bc2018e-5e89-45c8-98fe-1b9891361a8e",
-        "name": "ANico09",
-        "level": 4,
-        "bypassesPlayerLimit": false
-	},
-	{
-		"uuid": "10b8cd93-3e0f-46cf-ab9e-f337a6747559",
-        "name": "Haz3e",
-        "level": 4,
-        "bypassesPlayerLimit": false
+	ops, err := json.MarshalIndent(cfg.Ops, "", "    ")
+	if err != nil {
+		return err
 	}
-]`
-	if err := os.WriteFile(filepath.Join(dir, "ops.json"), []byte(ops), 0644); err != nil {
+	if err := os.WriteFile(filepath.Join(dir, "ops.json"), ops, 0644); err != nil {
 		return err
 	}
 
@@ -226,54 +172,44 @@ online-mode=false
 		return fmt.Errorf("failed copying paper.jar: %w", err)
 	}
 
-	// If you now have a local "plugins" folder, copy it entirely into the server dir.
-	localPluginsFolder := "plugins" // <-- local plugins folder you prepared
+	// Copy the template's plugin set entirely into the server dir.
 	serverPluginsFolder := filepath.Join(dir, "plugins")
 
 	// Make sure destination plugins folder exists; copyDir will create it anyway.
-	if err := copyDir(localPluginsFolder, serverPluginsFolder); err != nil {
+	if err := copyDir(t.Plugins, serverPluginsFolder); err != nil {
 		return fmt.Errorf("failed copying plugins folder: %w", err)
 	}
 
-	// Write LunexiaMain config (plugin folder)
-	configLunexia := fmt.Sprintf(
-		`type: "%s"
-subtype: ""`, name)
-	if strings.HasPrefix(name, "lunaris_asteroid_") {
-		configLunexia =
-			`type: "lunaris"
-subtype: "asteroid"`
-	}
-
-	lunexiaDst := filepath.Join(serverPluginsFolder, "LunexiaMain")
-	if err := os.MkdirAll(lunexiaDst, 0755); err != nil {
-		return err
-	}
-	if err := os.WriteFile(filepath.Join(lunexiaDst, "config.yml"), []byte(configLunexia), 0644); err != nil {
-		return err
+	// Write the template's plugin configs (e.g. LunexiaMain/config.yml)
+	for file, content := range t.PluginConfigs {
+		dst := filepath.Join(serverPluginsFolder, file)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, []byte(t.expand(content, name)), 0644); err != nil {
+			return err
+		}
 	}
 
-	worldURL := fmt.Sprintf("https://raw.githubusercontent.com/JuMaEn16/lunexia-worlds/main/%s.zip", name)
-	if strings.HasPrefix(name, "lunaris_asteroid_") {
-		worldURL = fmt.Sprintf("https://raw.githubusercontent.com/JuMaEn16/lunexia-worlds/main/lunaris_asteroid/%s.zip", name)
-
+	world := t.WorldFile(name)
+	if fallback := t.FallbackWorldFile(name); fallback != "" {
 		result := make(chan error)
-		DownloadWorldAsync(worldURL, token, dir, result)
+		DownloadWorldAsync(world, dir, job, result)
 
-		fmt.Println("[World] Waiting for download of specific player + extraction...")
+		fmt.Println("[World] Waiting for download of specific world + extraction...")
 		if err := <-result; err != nil {
-			fmt.Printf("[World] Install failed for specific player: %v\n", err)
+			fmt.Printf("[World] Install failed for specific world: %v\n", err)
 		} else {
 			return nil
 		}
 
-		fmt.Printf("[World] Falling back to default asteroid world for '%s'\n", name)
+		fmt.Printf("[World] Falling back to %s for '%s'\n", fallback, name)
 
-		worldURL = "https://raw.githubusercontent.com/JuMaEn16/lunexia-worlds/main/lunaris_asteroid.zip"
+		world = fallback
 	}
 
 	result := make(chan error)
-	DownloadWorldAsync(worldURL, token, dir, result)
+	DownloadWorldAsync(world, dir, job, result)
 
 	fmt.Println("[World] Waiting for download + extraction...")
 	if err := <-result; err != nil {
@@ -341,10 +277,12 @@ func copyDir(src string, dst string) error {
 	return nil
 }
 
+// DownloadWorldAsync installs the world key of the world store as the world
+// of the server in destDir and sends the outcome to result.
 func DownloadWorldAsync(
-	url string,
-	token string,
+	key string,
 	destDir string,
+	job *Job,
 	result chan<- error,
 ) {
 	go func() {
@@ -353,7 +291,8 @@ func DownloadWorldAsync(
 		fmt.Println("[World] Starting world download...")
 
 		// STEP 1: Download ZIP with progress
-		if err := downloadWithProgress(url, zipPath, token); err != nil {
+		job.setPhase(PhaseDownloading)
+		if err := downloadWorld(key, zipPath, job); err != nil {
 			result <- fmt.Errorf("download failed: %w", err)
 			return
 		}
@@ -369,6 +308,7 @@ func DownloadWorldAsync(
 		}
 
 		// STEP 4: Extract new world
+		job.setPhase(PhaseExtracting)
 		fmt.Println("[World] Extracting world...")
 		if err := unzip(zipPath, worldDir); err != nil {
 			result <- fmt.Errorf("extract failed: %w", err)
@@ -380,58 +320,17 @@ func DownloadWorldAsync(
 	}()
 }
 
-func downloadWithProgress(url, dest, token string) error {
-	client := &http.Client{}
-
-	req, _ := http.NewRequest("GET", url, nil)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
+// downloadWorld writes the world key to the file dest.
+func downloadWorld(key, dest string, job *Job) error {
 	out, err := os.Create(dest)
 	if err != nil {
 		return err
 	}
-	defer out.Close()
-
-	total := resp.ContentLength
-	var downloaded int64
-	buf := make([]byte, 32*1024)
-
-	start := time.Now()
-	lastPrint := time.Now()
-
-	fmt.Println("[World] Downloading...")
-
-	for {
-		n, err := resp.Body.Read(buf)
-		if n > 0 {
-			_, wErr := out.Write(buf[:n])
-			if wErr != nil {
-				return wErr
-			}
-			downloaded += int64(n)
-		}
-
-		if time.Since(lastPrint) >= time.Second {
-			percent := float64(downloaded) / float64(total) * 100
-			speed := float64(downloaded) / time.Since(start).Seconds() / 1024 / 1024
-			fmt.Printf("[World] %.1f%% (%.2f MB/s)\n", percent, speed)
-			lastPrint = time.Now()
-		}
-
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
+	if err := worlds.Get(key, out, job.setProgress); err != nil {
+		out.Close()
+		return err
 	}
-
-	return nil
+	return out.Close()
 }
 
 func unzip(src, dest string) error {
@@ -441,37 +340,8 @@ func unzip(src, dest string) error {
 	}
 	defer r.Close()
 
-	for _, f := range r.File {
-		fpath := filepath.Join(dest, f.Name)
-
-		if f.FileInfo().IsDir() {
-			if err := os.MkdirAll(fpath, 0755); err != nil {
-				return err
-			}
-			continue
-		}
-
-		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
-			return err
-		}
-
-		in, err := f.Open()
-		if err != nil {
-			return err
-		}
-		defer in.Close()
-
-		out, err := os.OpenFile(fpath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, f.Mode())
-		if err != nil {
-			return err
-		}
-		defer out.Close()
-
-		if _, err := io.Copy(out, in); err != nil {
-			return err
-		}
-	}
-	return nil
+	_, err = extractZip(&r.Reader, "", dest)
+	return err
 }
 
 func copyFile(src, dst string) error {
@@ -495,327 +365,165 @@ func copyFile(src, dst string) error {
 	return out.Sync()
 }
 
-func allocatePort() int {
-	serversMux.Lock()
-	defer serversMux.Unlock()
-
-	if available.Len() > 0 {
-		p := heap.Pop(available).(int)
-		return p
-	}
-	p := nextPort
-	nextPort++
-	return p
-}
-
-func releasePort(p int) {
-	// ignore invalid ports <= 0
-	if p <= 0 {
-		return
-	}
-	serversMux.Lock()
-	defer serversMux.Unlock()
-	heap.Push(available, p)
-}
-
-// ---------- startServerHandler (waits for "Done" and uses lowest port) ----------
+// startServerHandler registers the server and starts it in the background. It
+// answers 202 with the ID of the job to follow on /jobs/{id}; asking again
+// while the server is still starting returns the same job.
 func startServerHandler(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get("name")
 	if name == "" {
 		http.Error(w, "Missing 'name' query parameter", http.StatusBadRequest)
 		return
 	}
-
-	// ensure only one server per name
-	mu.Lock()
-	if _, exists := serverMap[name]; exists {
-		mu.Unlock()
-		http.Error(w, "Server already running", http.StatusBadRequest)
+	// the name ends up in paths, e.g. the log directory
+	if !namePattern.MatchString(name) {
+		http.Error(w, "Invalid 'name': use letters, digits, '_', '-' and '.'", http.StatusBadRequest)
 		return
 	}
-	mu.Unlock()
-
-	// get lowest available port (from heap or nextPort)
-	port := allocatePort()
-	// if anything fails before registration, return the port to pool
-	allocatedAndPending := true
-	defer func() {
-		if allocatedAndPending {
-			// something failed; make port available again
-			releasePort(port)
-		}
-	}()
-
-	dir := fmt.Sprintf("paper_server_%d", port)
-	if err := setupServerDir(dir, port, name); err != nil {
-		http.Error(w, "Failed to set up server directory: "+err.Error(), http.StatusInternalServerError)
+	tmpl, err := cfg.template(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	// build command
-	cmd := exec.Command(
-		"java",
-		"-Xmx2G", "-Xms2G",
-		"-jar", "paper.jar",
-		"--nogui",
-	)
-	cmd.Dir = dir
-
-	// capture output so we can wait for "Done"
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		http.Error(w, "Failed to create stdout pipe: "+err.Error(), http.StatusInternalServerError)
+	jvm := tmpl.jvm()
+	if m := r.URL.Query().Get("memory"); m != "" {
+		if _, err := parseMemory(m); err != nil {
+			http.Error(w, "Invalid 'memory': "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		jvm.Memory = m
+	}
+	// refuse before downloading the world if the heap can't fit anyway
+	if err := checkFreeMemory(jvm.Memory); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		return
 	}
-	cmd.Stderr = cmd.Stdout
 
-	// start
-	if err := cmd.Start(); err != nil {
-		http.Error(w, "Failed to start server: "+err.Error(), http.StatusInternalServerError)
+	// ensure only one server per name; a crashed one is replaced
+	srv := &Server{Name: name, Status: StateCreating, JVM: jvm}
+	mu.Lock()
+	old, exists := serverMap[name]
+	if exists && old.job != nil && (old.Status == StateCreating || old.Status == StateStarting) {
+		mu.Unlock()
+		writeJob(w, old.job)
 		return
 	}
-
-	// monitor output for the "Done" line
-	started := make(chan struct{})
-	go func() {
-		scanner := bufio.NewScanner(stdoutPipe)
-		for scanner.Scan() {
-			line := scanner.Text()
-			fmt.Println(line) // still emit to host console
-
-			// match typical Paper/Bukkit done message
-			if strings.Contains(line, "Done") && strings.Contains(line, "For help") {
-				close(started)
-				return
-			}
-		}
-		// if scanner ends without "Done", close channel (caller will timeout)
-		close(started)
-	}()
-
-	// wait for "Done" or timeout
-	select {
-	case <-started:
-		// either success (Done found) or scanner closed — we still check by probing server process state below
-	case <-time.After(60 * time.Second):
-		// timeout: kill process and return error
-		_ = cmd.Process.Kill()
-		http.Error(w, "Server start timed out", http.StatusGatewayTimeout)
+	if exists && old.Status != StateCrashed {
+		mu.Unlock()
+		http.Error(w, fmt.Sprintf("Server already exists (%s)", old.Status), http.StatusBadRequest)
 		return
 	}
-
-	// At this point server has produced lines and likely started. Register it.
-	srv := &Server{ID: port, Port: port, Cmd: cmd, Status: "running"}
-
-	serversMux.Lock()
-	servers[srv.ID] = srv
-	serversMux.Unlock()
-
-	mu.Lock()
+	srv.job = newJob(name)
 	serverMap[name] = srv
+	saveInstanceTableLocked()
+	if exists {
+		old.logFile.close()
+	}
 	mu.Unlock()
+	if exists {
+		ports.release(old.Port)
+		old.consoleRing().close()
+	}
 
-	// mark allocation as completed — don't put the port back in the pool
-	allocatedAndPending = false
+	go startServer(srv, tmpl)
+	writeJob(w, srv.job)
+}
 
-	fmt.Printf("Paper server '%s' fully started on port %d\n", name, srv.Port)
+func writeJob(w http.ResponseWriter, job *Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(map[string]any{
-		"port": srv.Port,
+		"job": job.ID,
 	})
 }
 
-// /// Example stop handler that returns the freed port to the pool /////
-func stopServerHandler(w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Query().Get("name")
-	if name == "" {
-		http.Error(w, "Missing 'name' query parameter", http.StatusBadRequest)
-		return
-	}
+// startServer sets up and launches the new server srv, which is creating,
+// reporting the progress to srv.job.
+func startServer(srv *Server, tmpl *Template) {
+	job := srv.job
 
-	// find server by name and remove mapping
-	mu.Lock()
-	srv, exists := serverMap[name]
-	if !exists {
-		mu.Unlock()
-		http.Error(w, fmt.Sprintf("Server '%s' not found", name), http.StatusNotFound)
+	// lowest free port of the range
+	port, err := ports.allocate(srv.Name)
+	if err != nil {
+		_ = srv.setState(StateCrashed)
+		job.fail(err)
 		return
 	}
-	delete(serverMap, name)
+	dir := serverDir(srv.Name)
+	mu.Lock()
+	srv.Port = port
+	srv.Dir = dir
 	mu.Unlock()
+	job.setPort(port)
 
-	// stop actual server by ID and remove from servers map
-	serversMux.Lock()
-	realSrv, ok := servers[srv.ID]
-	if !ok {
-		serversMux.Unlock()
-		http.Error(w, fmt.Sprintf("Server '%s' exists but internal server ID %d not found", name, srv.ID), http.StatusInternalServerError)
+	if err := setupServerDir(dir, port, srv.Name, tmpl, job); err != nil {
+		_ = srv.setState(StateCrashed)
+		job.fail(fmt.Errorf("failed to set up server directory: %w", err))
 		return
 	}
 
-	// graceful stop
-	if err := realSrv.Cmd.Process.Signal(syscall.SIGINT); err != nil {
-		serversMux.Unlock()
-		http.Error(w, "Failed to stop server: "+err.Error(), http.StatusInternalServerError)
+	if err := srv.setState(StateStarting); err != nil {
+		job.fail(err)
 		return
 	}
-
-	delete(servers, realSrv.ID)
-	serversMux.Unlock()
-
-	// return the port to the pool so it becomes the lowest available next time
-	releasePort(realSrv.ID)
-
-	fmt.Printf("Stopped server '%s' (ID %d)\n", name, realSrv.ID)
-	w.Write([]byte(fmt.Sprintf("Server '%s' stopped", name)))
-}
-
-func stopServerHold(name string, srv *Server) error {
-	cmdPtr := srv.Cmd
-
-	// 1. Check if process is valid
-	if cmdPtr == nil || cmdPtr.Process == nil {
-		log.Printf("Server '%s' process not available (already stopped?)", name)
-		// Ensure status is "stopped" (nil)
-		mu.Lock()
-		serverMap[name].Status = "restarting"
-		mu.Unlock()
-		return fmt.Errorf("server process not available (already stopped?)")
-	}
-
-	// 2. Signal SIGINT then wait with timeout
-	if err := cmdPtr.Process.Signal(syscall.SIGINT); err != nil {
-		return fmt.Errorf("failed to signal server: %v", err)
+	job.setPhase(PhaseLaunching)
+	if err := launch(srv); err != nil {
+		_ = srv.setState(StateCrashed)
+		job.fail(err)
+		return
 	}
-
-	waitCh := make(chan error, 1)
-	go func() {
-		waitCh <- cmdPtr.Wait()
-	}()
-
-	select {
-	case err := <-waitCh:
-		if err != nil && !errors.Is(err, os.ErrProcessDone) {
-			// Log but continue
-			log.Printf("server process wait for '%s' returned err (continuing): %v", name, err)
-		}
-	case <-time.After(30 * time.Second):
-		// didn't exit in time — force kill
-		log.Printf("Server '%s' did not stop in 30s, killing...", name)
-		_ = cmdPtr.Process.Kill()
-		<-waitCh // wait for Wait() to return
+	if err := srv.setState(StateRunning); err != nil {
+		job.fail(err)
+		return
 	}
+	job.setPhase(PhaseDone)
 
-	log.Printf("Server '%s' process stopped.", name)
-
-	// 3. Remove process references from maps
-
-	return nil
+	fmt.Printf("Paper server '%s' fully started on port %d\n", srv.Name, port)
 }
 
-func startHeldServer(name string, port int, dir string) error {
-	// 1. Set status to "restarting"
-	// Create a new Server object for the new process, including the cleanupOnce guard
-	srv := &Server{ID: port, Port: port, Status: "restarting", Cmd: nil}
+// lookupServer returns the server registered under name and moves it to
+// state to, or writes an error response and returns nil.
+func lookupServer(w http.ResponseWriter, name string, to State) *Server {
 	mu.Lock()
-	serverMap[name] = srv // <-- STATUS UPDATE 3
-	log.Printf("Server '%s' status set to 'restarting'", name)
-	mu.Unlock()
-
-	localPluginsFolder := "plugins" // <-- local plugins folder you prepared
-	serverPluginsFolder := filepath.Join(dir, "plugins")
-
-	// Make sure destination plugins folder exists; copyDir will create it anyway.
-	if err := copyDir(localPluginsFolder, serverPluginsFolder); err != nil {
-		return fmt.Errorf("failed copying plugins folder: %w", err)
+	defer mu.Unlock()
+	srv, exists := serverMap[name]
+	if !exists {
+		http.Error(w, fmt.Sprintf("Server '%s' not found", name), http.StatusNotFound)
+		return nil
 	}
-
-	// 2. start server again (same port/dir/name)
-	cmd := exec.Command(
-		"java",
-		"-Xmx2G", "-Xms2G",
-		"-jar", "paper.jar",
-		"--nogui",
-	)
-	cmd.Dir = dir
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Printf("Failed to create stdout pipe for restart: %v", err)
-		// On error, set status back to stopped
-		mu.Lock()
-		serverMap[name] = nil
-		mu.Unlock()
-		return fmt.Errorf("failed to create stdout pipe for restart: %v", err)
+	if err := srv.setStateLocked(to); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return nil
 	}
-	cmd.Stderr = cmd.Stdout
+	return srv
+}
 
-	if err := cmd.Start(); err != nil {
-		log.Printf("Failed to restart server: %v", err)
-		// On error, set status back to stopped
-		mu.Lock()
-		serverMap[name] = nil
-		mu.Unlock()
-		return fmt.Errorf("failed to restart server: %v", err)
+// /// Stop handler that returns the freed port to the pool /////
+func stopServerHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "Missing 'name' query parameter", http.StatusBadRequest)
+		return
 	}
 
-	// Update the live server object with the Cmd reference
-	srv.Cmd = cmd
-
-	// 3. monitor "Done" similar to start-server
-	started := make(chan struct{})
-
-	// Function to safely close the 'started' channel once
-	safeCloseStarted := func() {
-		srv.cleanupOnce.Do(func() {
-			close(started)
-			log.Printf("Channel 'started' safely closed for '%s'.", name)
-		})
+	srv := lookupServer(w, name, StateStopping)
+	if srv == nil {
+		return
+	}
+	result, err := stopProcess(srv)
+	if err != nil {
+		// don't leave it stopping forever, crashed servers can be stopped again
+		_ = srv.setState(StateCrashed)
+		http.Error(w, "Failed to stop server: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+	_ = srv.setState(StateStopped)
 
-	go func() {
-		scanner := bufio.NewScanner(stdoutPipe)
-		for scanner.Scan() {
-			line := scanner.Text()
-			fmt.Println(line) // keep console output
-			if strings.Contains(line, "Done") && strings.Contains(line, "For help") {
-				safeCloseStarted() // Use safe closure 1
-				// Don't return here, let the pipe drain
-			}
-		}
-		log.Printf("Stdout pipe closed for '%s'", name)
-		safeCloseStarted() // Use safe closure 2 (will only run if 1 hasn't yet)
-	}()
+	// unregister and return the port to the pool so it becomes the lowest available next time
+	removeServer(srv)
 
-	// 4. Wait for start or timeout
-	select {
-	case <-started:
-		log.Printf("Server '%s' restart detected 'Done' line.", name)
-		// Success, update the server maps
-		mu.Lock()
-		// Update the server object status
-		if currentSrv, ok := serverMap[name]; ok && currentSrv != nil {
-			currentSrv.Status = "running" // <-- STATUS UPDATE 4
-			log.Printf("Server '%s' status set to 'running'", name)
-
-			// Add to the 'servers' map as well
-			serversMux.Lock()
-			servers[currentSrv.ID] = currentSrv
-			serversMux.Unlock()
-		} else {
-			log.Printf("Error: serverMap entry for '%s' was nil or missing after restart", name)
-		}
-		mu.Unlock()
-		return nil // Success
-
-	case <-time.After(60 * time.Second):
-		log.Printf("Server '%s' restart timed out after 60s.", name)
-		_ = cmd.Process.Kill()
-		// Set status back to "stopped"
-		mu.Lock()
-		serverMap[name] = nil
-		mu.Unlock()
-
-		return fmt.Errorf("server restart timed out")
-	}
+	fmt.Printf("Stopped server '%s' (port %d): %s\n", name, srv.Port, result.Outcome)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
 }
 
 func saveWorldHandler(w http.ResponseWriter, r *http.Request) {
@@ -824,6 +532,15 @@ func saveWorldHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing 'name' query parameter", http.StatusBadRequest)
 		return
 	}
+	// what asked for the save, recorded in the snapshot
+	trigger := r.URL.Query().Get("trigger")
+	if trigger == "" {
+		trigger = TriggerManual
+	}
+	if !validTrigger(trigger) {
+		http.Error(w, "Invalid 'trigger' query parameter", http.StatusBadRequest)
+		return
+	}
 
 	// HTTP client used for proxy calls
 	client := &http.Client{Timeout: 5 * time.Second}
@@ -831,7 +548,7 @@ func saveWorldHandler(w http.ResponseWriter, r *http.Request) {
 	// --- A. Evacuate players: call proxy /move_from_to BEFORE stopping the server ---
 	var movedPlayers []string
 	{
-		proxyUrl, err := url.Parse(proxyApiHost + "/move_from_to")
+		proxyUrl, err := url.Parse(cfg.ProxyAPIURL + "/move_from_to")
 		if err != nil {
 			log.Printf("CRITICAL: Failed to parse proxyApiHost URL: %v", err)
 			http.Error(w, "Internal configuration error: invalid proxy host", http.StatusInternalServerError)
@@ -841,8 +558,8 @@ func saveWorldHandler(w http.ResponseWriter, r *http.Request) {
 		q := url.Values{}
 		q.Add("origin", name)
 		// If we have a configured fallback and it's not the same server, ask to move players there.
-		if defaultFallback != "" && defaultFallback != name {
-			q.Add("destination", defaultFallback)
+		if cfg.DefaultFallback != "" && cfg.DefaultFallback != name {
+			q.Add("destination", cfg.DefaultFallback)
 		}
 		proxyUrl.RawQuery = q.Encode()
 
@@ -886,93 +603,52 @@ func saveWorldHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Proxy moved players away from '%s': %v", name, movedPlayers)
 	}
 
-	// --- B. locate server and set status to "restarting" ---
-	mu.Lock()
-	srv, exists := serverMap[name]
-	if !exists {
-		mu.Unlock()
-		http.Error(w, fmt.Sprintf("Server '%s' not found", name), http.StatusNotFound)
-		return
-	}
+	// --- B. locate server and set status to "saving" ---
+	srv := lookupServer(w, name, StateSaving)
 	if srv == nil {
-		mu.Unlock()
-		http.Error(w, fmt.Sprintf("Server '%s' is already stopped. Use 'start' instead.", name), http.StatusBadRequest)
 		return
 	}
-
-	// Mark as restarting
-	srv.Status = "restarting"
-	log.Printf("Server '%s' status set to 'restarting'", name)
-
-	// copy needed fields and release lock
-	port := srv.Port
+	mu.Lock()
+	port, dir := srv.Port, srv.Dir
 	mu.Unlock()
 
-	// compute server dir (same convention used when starting)
-	dir := fmt.Sprintf("paper_server_%d", port)
-	worldDir := filepath.Join(dir, "world")
-	if _, err := os.Stat(worldDir); os.IsNotExist(err) {
-		http.Error(w, fmt.Sprintf("World directory does not exist: %s", worldDir), http.StatusBadRequest)
-		return
-	}
-
 	// --- Stop Server Gracefully ---
-	// We call the new function. It handles its own locking.
-	if err := stopServerHold(name, srv); err != nil {
-		// stopServerHold already logged the details and updated maps if needed
+	result, err := stopProcess(srv)
+	if err != nil {
+		_ = srv.setState(StateCrashed)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	// --- Server is now stopped and de-registered ---
 
-	// create zip file (temporary)
-	tmpZip, err := os.CreateTemp("", fmt.Sprintf("%s-*.zip", name))
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create temp zip: %v", err), http.StatusInternalServerError)
-		return
+	// --- Server is now stopped: zip and upload the world ---
+	// a world that was neither saved nor shut down cleanly may be inconsistent
+	var snap *Snapshot
+	saveErr := errors.New("the server was killed before it saved the world")
+	if result.Saved || result.Outcome == StopGraceful {
+		snap, saveErr = saveWorld(name, dir, trigger)
 	}
-	zipPath := tmpZip.Name()
-	tmpZip.Close()
-	defer os.Remove(zipPath)
-
-	log.Printf("Zipping world for '%s'...", name)
-	if err := zipDir(worldDir, zipPath, []string{"advancements", "playerdata", "stats"}); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to zip world: %v", err), http.StatusInternalServerError)
-		return
-	}
-	// "owner/repo"
-	if token == "" || repoWorlds == "" {
-		log.Printf("No Token")
-		http.Error(w, "GitHub token/repo not set", http.StatusInternalServerError)
-		return
-	}
-
-	// destination path in repo: {name}.zip
-	destPath := path.Base(fmt.Sprintf("%s.zip", name))
-	if strings.HasPrefix(name, "lunaris_asteroid_") {
-		destPath = path.Join("lunaris_asteroid", fmt.Sprintf("%s.zip", name))
+	if saveErr != nil {
+		log.Printf("Saving world for '%s' failed, restarting without saving: %v", name, saveErr)
+	} else {
+		log.Printf("Upload complete for '%s'.", name)
 	}
 
-	log.Printf("Uploading world for '%s' to GitHub...", name)
-	if err := uploadFileToGitHub(zipPath, repoWorlds, destPath, token, fmt.Sprintf("Save world %s at %s", name, time.Now().UTC().Format(time.RFC3339))); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to upload to GitHub: %v", err), http.StatusInternalServerError)
+	// --- Server Restart ---
+	// restart even if the save failed so the unsaved world isn't lost
+	if err := srv.setState(StateRestarting); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
-	log.Printf("Upload complete for '%s'.", name)
-
-	// --- Server Restart ---
-	// Call the new startHeldServer function
-	if err := startHeldServer(name, port, dir); err != nil {
+	if err := relaunch(srv); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-
 	// --- C. After successful restart: ask proxy to move players BACK to this server ---
 	if len(movedPlayers) > 0 {
 		// Small sleep to give the restarted server a moment to accept connections
 		time.Sleep(1 * time.Second)
 
-		proxyUrl, err := url.Parse(proxyApiHost + "/move_list_to")
+		proxyUrl, err := url.Parse(cfg.ProxyAPIURL + "/move_list_to")
 		if err != nil {
 			log.Printf("CRITICAL: Failed to parse proxyApiHost URL for /move_list: %v", err)
 		} else {
@@ -1001,8 +677,53 @@ func saveWorldHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("No players were moved away from '%s' earlier; skipping /move_list.", name)
 	}
 
+	if saveErr != nil {
+		http.Error(w, fmt.Sprintf("Failed to save world, server restarted without saving: %v", saveErr), http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("World saved to GitHub as %s and server restarted on port %d", destPath, port)))
+	w.Write([]byte(fmt.Sprintf("World saved as snapshot %s and server restarted on port %d", snap.ID, port)))
+}
+
+// saveWorld zips the world of the stopped server name in dir and uploads it
+// to the template's world file in the world store and as a new snapshot,
+// which it returns.
+func saveWorld(name, dir, trigger string) (*Snapshot, error) {
+	worldDir := filepath.Join(dir, "world")
+	if _, err := os.Stat(worldDir); err != nil {
+		return nil, fmt.Errorf("world directory: %w", err)
+	}
+
+	// create zip file (temporary)
+	tmpZip, err := os.CreateTemp("", fmt.Sprintf("%s-*.zip", name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp zip: %w", err)
+	}
+	zipPath := tmpZip.Name()
+	tmpZip.Close()
+	defer os.Remove(zipPath)
+
+	log.Printf("Zipping world for '%s'...", name)
+	if err := zipDir(worldDir, zipPath, []string{"advancements", "playerdata", "stats"}); err != nil {
+		return nil, fmt.Errorf("failed to zip world: %w", err)
+	}
+	// destination in the world store: the template's world file
+	tmpl, err := cfg.template(name)
+	if err != nil {
+		return nil, err
+	}
+	destPath := path.Clean(tmpl.WorldFile(name))
+
+	log.Printf("Uploading world for '%s' to the world store...", name)
+	snap, err := createSnapshot(name, zipPath, trigger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	// the world file is the snapshot's zip again, copied by the store if it can
+	if err := putCopy(destPath, snap.Key, zipPath); err != nil {
+		return nil, fmt.Errorf("failed to upload world: %w", err)
+	}
+	return snap, nil
 }
 
 // zipDir zips all files inside srcDir into destZip (file path)
@@ -1077,111 +798,6 @@ func zipDir(srcDir, destZip string, blacklist []string) error {
 	})
 }
 
-func uploadFileToGitHub(localPath, repo, destPath, token, message string) error {
-	// --- Read local file ---
-	content, err := os.ReadFile(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to read local file: %w", err)
-	}
-	b64 := base64.StdEncoding.EncodeToString(content)
-
-	// --- Parse repo (owner/repo) ---
-	parts := strings.SplitN(repo, "/", 2)
-	if len(parts) != 2 {
-		return fmt.Errorf("repo must be owner/repo")
-	}
-	owner := parts[0]
-	repoName := parts[1]
-
-	// --- Fix token formatting ---
-	token = strings.TrimSpace(token)
-	authHeader := "token " + token // IMPORTANT → matches curl exactly
-
-	client := &http.Client{Timeout: 30 * time.Second}
-
-	// --- Build GET URL (only escape path, NOT owner/repo) ---
-	getURL := fmt.Sprintf(
-		"https://api.github.com/repos/%s/%s/contents/%s",
-		owner, repoName, url.PathEscape(destPath),
-	)
-
-	getReq, _ := http.NewRequest("GET", getURL, nil)
-	getReq.Header.Set("Authorization", authHeader)
-	getReq.Header.Set("Accept", "application/vnd.github+json")
-	getReq.Header.Set("User-Agent", "github-upload")
-
-	getResp, err := client.Do(getReq)
-	if err != nil {
-		return fmt.Errorf("failed GET request: %w", err)
-	}
-	bodyBytes, _ := io.ReadAll(getResp.Body)
-	getResp.Body.Close()
-
-	var sha string
-
-	// --- Interpret GET response ---
-	switch getResp.StatusCode {
-	case http.StatusOK:
-		// File exists → extract SHA
-		var info struct {
-			SHA string `json:"sha"`
-		}
-		if err := json.Unmarshal(bodyBytes, &info); err != nil {
-			return fmt.Errorf("failed to parse GET response: %w", err)
-		}
-		if info.SHA == "" {
-			return fmt.Errorf("github returned no sha for existing file")
-		}
-		sha = info.SHA
-
-	case http.StatusNotFound:
-		// File does not exist → create new
-		sha = ""
-
-	default:
-		// Unexpected error
-		return fmt.Errorf("GitHub GET returned %d: %s", getResp.StatusCode, string(bodyBytes))
-	}
-
-	// --- Build PUT body ---
-	reqBody := map[string]interface{}{
-		"message": message,
-		"content": b64,
-		"branch":  "main",
-	}
-	if sha != "" {
-		reqBody["sha"] = sha
-	}
-	jsonBody, _ := json.Marshal(reqBody)
-
-	// --- Build PUT request ---
-	putURL := fmt.Sprintf(
-		"https://api.github.com/repos/%s/%s/contents/%s",
-		owner, repoName, url.PathEscape(destPath),
-	)
-
-	putReq, _ := http.NewRequest("PUT", putURL, bytes.NewReader(jsonBody))
-	putReq.Header.Set("Authorization", authHeader)
-	putReq.Header.Set("Accept", "application/vnd.github+json")
-	putReq.Header.Set("User-Agent", "github-upload")
-	putReq.Header.Set("Content-Type", "application/json")
-
-	putResp, err := client.Do(putReq)
-	if err != nil {
-		return fmt.Errorf("PUT request failed: %w", err)
-	}
-	defer putResp.Body.Close()
-
-	respBody, _ := io.ReadAll(putResp.Body)
-
-	// Expect 200 (update) or 201 (create)
-	if putResp.StatusCode != http.StatusOK && putResp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("GitHub PUT %d: %s", putResp.StatusCode, string(respBody))
-	}
-
-	return nil
-}
-
 func restartWorldHandler(w http.ResponseWriter, r *http.Request) {
 	name := r.URL.Query().Get("name")
 	if name == "" {
@@ -1195,7 +811,7 @@ func restartWorldHandler(w http.ResponseWriter, r *http.Request) {
 	// --- A. Evacuate players: call proxy /move_from_to BEFORE stopping the server ---
 	var movedPlayers []string
 	{
-		proxyUrl, err := url.Parse(proxyApiHost + "/move_from_to")
+		proxyUrl, err := url.Parse(cfg.ProxyAPIURL + "/move_from_to")
 		if err != nil {
 			log.Printf("CRITICAL: Failed to parse proxyApiHost URL: %v", err)
 			http.Error(w, "Internal configuration error: invalid proxy host", http.StatusInternalServerError)
@@ -1206,8 +822,8 @@ func restartWorldHandler(w http.ResponseWriter, r *http.Request) {
 		q.Add("reason", "Server is restarting..")
 		q.Add("origin", name)
 		// If we have a configured fallback and it's not the same server, ask to move players there.
-		if defaultFallback != "" && defaultFallback != name {
-			q.Add("destination", defaultFallback)
+		if cfg.DefaultFallback != "" && cfg.DefaultFallback != name {
+			q.Add("destination", cfg.DefaultFallback)
 		}
 		proxyUrl.RawQuery = q.Encode()
 
@@ -1252,51 +868,23 @@ func restartWorldHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// --- B. locate server and set status to "restarting" ---
-	mu.Lock()
-	srv, exists := serverMap[name]
-	if !exists {
-		mu.Unlock()
-		http.Error(w, fmt.Sprintf("Server '%s' not found", name), http.StatusNotFound)
-		return
-	}
+	srv := lookupServer(w, name, StateRestarting)
 	if srv == nil {
-		mu.Unlock()
-		http.Error(w, fmt.Sprintf("Server '%s' is already stopped. Use 'start' instead.", name), http.StatusBadRequest)
 		return
 	}
-
-	// Mark as restarting
-	srv.Status = "restarting"
-	log.Printf("Server '%s' status set to 'restarting'", name)
-
-	// copy needed fields and release lock
+	mu.Lock()
 	port := srv.Port
 	mu.Unlock()
 
-	// compute server dir (same convention used when starting)
-	dir := fmt.Sprintf("paper_server_%d", port)
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		http.Error(w, fmt.Sprintf("Server directory does not exist: %s", dir), http.StatusBadRequest)
-		return
-	}
-
 	// --- 1. Stop Server Gracefully ---
-	if err := stopServerHold(name, srv); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	// --- Server is now stopped and de-registered ---
-	localPluginsFolder := "plugins"
-	serverPluginsFolder := filepath.Join(dir, "plugins")
-
-	// Make sure destination plugins folder exists; copyDir will create it anyway.
-	if err := copyDir(localPluginsFolder, serverPluginsFolder); err != nil {
+	if _, err := stopProcess(srv); err != nil {
+		_ = srv.setState(StateCrashed)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// --- 2. Server Restart ---
-	if err := startHeldServer(name, port, dir); err != nil {
+	// --- 2. Server Restart with refreshed plugins ---
+	if err := relaunch(srv); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -1306,7 +894,7 @@ func restartWorldHandler(w http.ResponseWriter, r *http.Request) {
 		// Small sleep to give the restarted server a moment to accept connections
 		time.Sleep(1 * time.Second)
 
-		proxyUrl, err := url.Parse(proxyApiHost + "/move_list_to")
+		proxyUrl, err := url.Parse(cfg.ProxyAPIURL + "/move_list_to")
 		if err != nil {
 			log.Printf("CRITICAL: Failed to parse proxyApiHost URL for /move_list: %v", err)
 		} else {
@@ -1445,67 +1033,13 @@ func RefreshPluginsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	// if topPrefix remains "", there is no top-level dir and names are root relative
 
-	extractedAny := false
-
-	for _, f := range zr.File {
-		rel := strings.TrimPrefix(f.Name, topPrefix)
-
-		// match the requested subdir exactly or any path inside it
-		if rel == subdir || strings.HasPrefix(rel, subdir+"/") {
-			extractedAny = true
-
-			relInside := strings.TrimPrefix(rel, subdir)
-			relInside = strings.TrimPrefix(relInside, "/")
-			outPath := filepath.Join(dest, relInside)
-
-			if f.FileInfo().IsDir() {
-				// create directory (use 0755 so we don't accidentally set weird modes)
-				if err := os.MkdirAll(outPath, 0755); err != nil {
-					fail("mkdir failed", err)
-					return
-				}
-				continue
-			}
-
-			rc, err := f.Open()
-			if err != nil {
-				fail("reading zipped file failed", err)
-				return
-			}
-
-			if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
-				rc.Close()
-				fail("making parent dirs failed", err)
-				return
-			}
-
-			// use sensible file perms, but preserve execute bit if present
-			mode := f.Mode()
-			if mode&0100 == 0 {
-				// ensure writable bits for owner if not executable
-				mode = (mode & os.FileMode(0777)) | 0644
-			}
-
-			outFile, err := os.OpenFile(outPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
-			if err != nil {
-				rc.Close()
-				fail("writing extracted file failed", err)
-				return
-			}
-
-			if _, err := io.Copy(outFile, rc); err != nil {
-				rc.Close()
-				outFile.Close()
-				fail("copying file failed", err)
-				return
-			}
-
-			rc.Close()
-			outFile.Close()
-		}
+	n, err := extractZip(&zr.Reader, topPrefix+subdir, dest)
+	if err != nil {
+		fail("extracting plugins failed", err)
+		return
 	}
 
-	if !extractedAny {
+	if n == 0 {
 		http.Error(w, "subdirectory not found in repo: "+subdir, http.StatusInternalServerError)
 		return
 	}
@@ -1517,27 +1051,71 @@ func RefreshPluginsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	// the config is per node and lives outside the updated tree; the
+	// bootstrapper exports its path
+	defaultConfigPath := os.Getenv("IM_CONFIG")
+	if defaultConfigPath == "" {
+		defaultConfigPath = "../instance_manager.json"
+	}
+	configPath := flag.String("config", defaultConfigPath, "path of the JSON config file (default: $IM_CONFIG)")
+	flag.Parse()
 
 	err := godotenv.Load("../.env")
 	if err != nil {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
-	token = os.Getenv("GITHUB_TOKEN")
-	if token == "" {
-		log.Fatal("GITHUB_TOKEN not found in environment")
+	if seeded, err := seedConfig(*configPath); err != nil {
+		log.Fatalf("Failed to create config: %v", err)
+	} else if seeded {
+		log.Printf("%s not found, created it from %s; review it for this node", *configPath, exampleConfigPath)
+	}
+	// after .env so that it can carry the overrides too
+	cfg, err = loadConfig(*configPath, os.Getenv)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	for _, p := range []string{cfg.PortRegistry, cfg.InstanceTable} {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			log.Fatalf("Failed to create data directory: %v", err)
+		}
+	}
+	ports, err = loadPortRegistry(cfg.PortRegistry)
+	if err != nil {
+		log.Fatalf("Failed to load port registry: %v", err)
+	}
+	if err := ports.reconcile(); err != nil {
+		log.Fatalf("Failed to reconcile port registry: %v", err)
+	}
+	instanceTablePath = cfg.InstanceTable
+	if err := adoptInstances(); err != nil {
+		log.Fatalf("Failed to load instance table: %v", err)
+	}
+	go collectServerDirsPeriodically()
+
+	worlds, err = newWorldStore(cfg, os.Getenv("GITHUB_TOKEN"))
+	if err != nil {
+		log.Fatalf("Failed to set up world store: %v", err)
 	}
 
 	http.HandleFunc("/system", systemHandler)
 	http.HandleFunc("/start-server", startServerHandler)
+	http.HandleFunc("/jobs/{id}", jobHandler)
+	http.HandleFunc("/console", consoleHandler)
+	http.HandleFunc("/command", commandHandler)
+	http.HandleFunc("/logs", logsHandler)
+	http.HandleFunc("/logs/download", logDownloadHandler)
+	http.HandleFunc("/gc", gcHandler)
 	http.HandleFunc("/stop-server", stopServerHandler)
 	http.HandleFunc("/save-instance", saveWorldHandler)
 	http.HandleFunc("/restart-instance", restartWorldHandler)
+	http.HandleFunc("/snapshots", snapshotsHandler)
+	http.HandleFunc("/snapshots/restore", restoreSnapshotHandler)
 	http.HandleFunc("/update-plugins", RefreshPluginsHandler)
 
-	port := 8000
-	log.Printf("Server running on http://localhost:%d\n", port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil); err != nil {
+	log.Printf("Server running on %s\n", cfg.Listen)
+	if err := http.ListenAndServe(cfg.Listen, nil); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeJava writes a script standing in for the java binary and returns JVM
+// options running it.
+func fakeJava(t *testing.T, script string) JVMOptions {
+	t.Helper()
+	java := filepath.Join(t.TempDir(), "java")
+	if err := os.WriteFile(java, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return JVMOptions{Java: java, Memory: "1M"}
+}
+
+// useTestConfig makes cfg the default config with the logs in a temporary
+// directory.
+func useTestConfig(t *testing.T) *Config {
+	t.Helper()
+	c := defaultConfig()
+	c.Logs.Dir = t.TempDir()
+	c.ServerManagerURL = ""
+	old := cfg
+	cfg = &c
+	t.Cleanup(func() { cfg = old })
+	return cfg
+}
+
+func TestStateTransitions(t *testing.T) {
+	srv := &Server{Name: "lobby", Status: StateCreating}
+	for _, to := range []State{StateStarting, StateRunning, StateSaving, StateRestarting, StateRunning, StateStopping, StateStopped} {
+		if err := srv.setState(to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, to := range []State{StateRunning, StateStarting, StateRestarting} {
+		if err := srv.setState(to); !errors.Is(err, errInvalidTransition) {
+			t.Errorf("stopped -> %s: err = %v, want errInvalidTransition", to, err)
+		}
+	}
+}
+
+func TestLaunchAndStop(t *testing.T) {
+	useTestConfig(t)
+	srv := &Server{
+		Name: "lobby",
+		Dir:  t.TempDir(),
+		JVM: fakeJava(t, `echo 'Done (1.234s)! For help, type "help"'
+while read line; do
+	case "$line" in
+	save-all) echo 'Saved the game' ;;
+	stop) echo 'Stopping server'; echo 'Saving chunks for level ServerLevel[world]'; exit 0 ;;
+	esac
+done
+`),
+	}
+	if err := launch(srv); err != nil {
+		t.Fatal(err)
+	}
+	result, err := stopProcess(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (StopResult{Outcome: StopGraceful, Saved: true}) {
+		t.Errorf("result = %+v", result)
+	}
+	select {
+	case <-srv.exited:
+	default:
+		t.Fatal("process still running after stopProcess")
+	}
+}
+
+func TestStopKilled(t *testing.T) {
+	c := useTestConfig(t)
+	c.Stop = StopConfig{SaveTimeout: Duration(100 * time.Millisecond), Timeout: Duration(200 * time.Millisecond)}
+	srv := &Server{
+		Name: "lobby",
+		Dir:  t.TempDir(),
+		JVM:  fakeJava(t, "trap '' INT\necho 'Done (1.234s)! For help, type \"help\"'\nwhile true; do sleep 0.1; done\n"),
+	}
+	if err := launch(srv); err != nil {
+		t.Fatal(err)
+	}
+	result, err := stopProcess(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (StopResult{Outcome: StopKilled}) {
+		t.Errorf("result = %+v, want killed without save", result)
+	}
+}
+
+func TestStopFailureMarksCrashed(t *testing.T) {
+	useTestConfig(t)
+	// a process that is gone without its exit being noticed can't be
+	// signalled
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Name: "lobby", Status: StateRunning, Process: cmd.Process, exited: make(chan struct{}), console: newLineRing(consoleLines)}
+	mu.Lock()
+	serverMap["lobby"] = srv
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(serverMap, "lobby")
+		mu.Unlock()
+	})
+
+	rec := httptest.NewRecorder()
+	stopServerHandler(rec, httptest.NewRequest("POST", "/stop-server?name=lobby", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("stop = %d %s, want 500", rec.Code, rec.Body)
+	}
+	if state := srv.state(); state != StateCrashed {
+		t.Fatalf("state = %s, want crashed", state)
+	}
+	// and it can be stopped again
+	if err := srv.setState(StateStopping); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLaunchExitBeforeDone(t *testing.T) {
+	useTestConfig(t)
+	srv := &Server{Name: "lobby", Dir: t.TempDir(), JVM: fakeJava(t, "echo 'Error: Unable to access jarfile paper.jar'\nexit 1\n")}
+	if err := launch(srv); err == nil {
+		t.Fatal("launch succeeded although the server exited")
+	}
+}
+
+func TestRestartPolicy(t *testing.T) {
+	p := RestartPolicy{Mode: RestartOnFailure, Backoff: Duration(time.Second), MaxBackoff: Duration(5 * time.Second), MaxRestarts: 3}
+	if p.shouldRestart(0, 0) || !p.shouldRestart(1, 2) || p.shouldRestart(1, 3) {
+		t.Error("on-failure restarted a clean exit or exceeded max_restarts")
+	}
+	for n, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := p.delay(n); got != want {
+			t.Errorf("delay(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestCrashRestart(t *testing.T) {
+	useTestConfig(t).Templates = []Template{{
+		Name:    "default",
+		Pattern: "*",
+		Plugins: t.TempDir(),
+		Restart: &RestartPolicy{Mode: RestartAlways, MaxRestarts: 1},
+	}}
+	srv := &Server{
+		Name:   "lobby",
+		Dir:    t.TempDir(),
+		Status: StateStarting,
+		JVM:    fakeJava(t, "echo 'Done (1.234s)! For help, type \"help\"'\nsleep 0.2\necho boom\nexit 3\n"),
+	}
+	oldPorts, oldTable := ports, instanceTablePath
+	t.Cleanup(func() { ports, instanceTablePath = oldPorts, oldTable })
+	var err error
+	if ports, err = loadPortRegistry(filepath.Join(t.TempDir(), "ports.json")); err != nil {
+		t.Fatal(err)
+	}
+	instanceTablePath = filepath.Join(t.TempDir(), "instances.json")
+	if srv.Port, err = ports.allocate(srv.Name); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	serverMap[srv.Name] = srv
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(serverMap, srv.Name)
+		mu.Unlock()
+	})
+
+	if err := launch(srv); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.setState(StateRunning); err != nil {
+		t.Fatal(err)
+	}
+
+	// crashes, is restarted once, crashes again and stays crashed
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		mu.Lock()
+		status, restarts, code, lastLog := srv.Status, srv.restarts, srv.ExitCode, srv.LastLog
+		mu.Unlock()
+		if status == StateCrashed && restarts == 1 {
+			crashHandlers.Wait()
+			if code == nil || *code != 3 {
+				t.Errorf("exit code = %v, want 3", code)
+			}
+			if len(lastLog) == 0 || lastLog[len(lastLog)-1] != "boom" {
+				t.Errorf("last log = %q", lastLog)
+			}
+			// no restart follows, so the port is free again
+			if len(ports.entries()) != 0 || srv.Port != 0 {
+				t.Errorf("port %d still allocated: %v", srv.Port, ports.entries())
+			}
+			var records []instanceRecord
+			if b, err := os.ReadFile(instanceTablePath); err != nil || json.Unmarshal(b, &records) != nil || len(records) != 1 || records[0].Port != 0 {
+				t.Errorf("instance table = %+v, %v", records, err)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server is %s after %d restarts", status, restarts)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestConsoleCommand(t *testing.T) {
+	useTestConfig(t)
+	srv := &Server{
+		Name: "lobby",
+		Dir:  t.TempDir(),
+		JVM: fakeJava(t, `echo 'Done (1.234s)! For help, type "help"'
+while read line; do
+	echo "ran $line"
+	[ "$line" = stop ] && exit 0
+done
+`),
+	}
+	if err := launch(srv); err != nil {
+		t.Fatal(err)
+	}
+	backlog, lines, cancel := srv.consoleRing().subscribe(10)
+	defer cancel()
+	if len(backlog) != 1 {
+		t.Errorf("backlog = %q, want the Done line", backlog)
+	}
+
+	for _, command := range []string{"say hi", "stop"} {
+		if err := sendCommand(srv, command); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"> " + command, "ran " + command} {
+			select {
+			case got := <-lines:
+				if got != want {
+					t.Errorf("console line %q, want %q", got, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("no console line %q", want)
+			}
+		}
+	}
+	select {
+	case <-srv.exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process still running after stop")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// adoptedPollInterval is how often an adopted process is checked for exit.
+const adoptedPollInterval = time.Second
+
+// instanceTablePath is the file serverMap is persisted to; empty disables
+// persisting. Set at startup.
+var instanceTablePath string
+
+// instanceRecord is a server as persisted in the instance table.
+type instanceRecord struct {
+	Name   string     `json:"name"`
+	Port   int        `json:"port"`
+	PID    int        `json:"pid,omitempty"`
+	Dir    string     `json:"dir"`
+	Status State      `json:"status"`
+	JVM    JVMOptions `json:"jvm"`
+}
+
+// saveInstanceTableLocked writes serverMap to the instance table; mu must be
+// held. Failures are only logged.
+func saveInstanceTableLocked() {
+	if instanceTablePath == "" {
+		return
+	}
+	records := make([]instanceRecord, 0, len(serverMap))
+	for _, srv := range serverMap {
+		rec := instanceRecord{Name: srv.Name, Port: srv.Port, Dir: srv.Dir, Status: srv.Status, JVM: srv.JVM}
+		if srv.Process != nil {
+			rec.PID = srv.Process.Pid
+		}
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err == nil {
+		tmp := instanceTablePath + ".tmp"
+		if err = os.WriteFile(tmp, append(b, '\n'), 0644); err == nil {
+			err = os.Rename(tmp, instanceTablePath)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to save instance table: %v", err)
+	}
+}
+
+// adoptInstances fills serverMap from the instance table left by the previous
+// IM process. Servers whose Java process is still running are adopted as
+// running; the others are registered as crashed so the server manager
+// starts them again. The port registry must be reconciled already.
+func adoptInstances() error {
+	b, err := os.ReadFile(instanceTablePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []instanceRecord
+	if err := json.Unmarshal(b, &records); err != nil {
+		return fmt.Errorf("parsing %s: %w", instanceTablePath, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, rec := range records {
+		if rec.Status == StateStopping || rec.Status == StateStopped {
+			continue
+		}
+		srv := &Server{Name: rec.Name, Dir: rec.Dir, JVM: rec.JVM, console: newLineRing(consoleLines)}
+
+		proc, err := os.FindProcess(rec.PID)
+		if err == nil && javaRunningIn(rec.PID, rec.Dir) {
+			exited := make(chan struct{})
+			srv.Port, srv.Process, srv.exited = rec.Port, proc, exited
+			srv.Status = StateRunning
+			srv.startedAt = time.Now()
+			ports.adopt(rec.Port, rec.Name, rec.Dir)
+			go watchAdopted(srv, proc, exited)
+			log.Printf("Adopted server '%s' (pid %d, port %d, was %s)", rec.Name, rec.PID, rec.Port, rec.Status)
+		} else {
+			// its port was freed when reconciling
+			srv.Status = StateCrashed
+			log.Printf("Server '%s' (pid %d, port %d) ended while the instance manager was down", rec.Name, rec.PID, rec.Port)
+		}
+		serverMap[rec.Name] = srv
+	}
+	saveInstanceTableLocked()
+	return nil
+}
+
+// watchAdopted waits for the adopted process proc of srv to exit. It isn't a
+// child of this IM, so it is polled instead of waited for and its exit code
+// is unknown.
+func watchAdopted(srv *Server, proc *os.Process, exited chan struct{}) {
+	for proc.Signal(syscall.Signal(0)) == nil {
+		time.Sleep(adoptedPollInterval)
+	}
+	log.Printf("Server '%s' adopted process exited", srv.Name)
+	close(exited)
+	if srv.state() == StateRunning {
+		crashHandlers.Add(1)
+		go handleCrash(srv, proc, -1)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAdoptInstances(t *testing.T) {
+	useTestConfig(t)
+	oldPorts, oldTable := ports, instanceTablePath
+	t.Cleanup(func() { ports, instanceTablePath = oldPorts, oldTable })
+	var err error
+	if ports, err = loadPortRegistry(filepath.Join(t.TempDir(), "ports.json")); err != nil {
+		t.Fatal(err)
+	}
+	instanceTablePath = filepath.Join(t.TempDir(), "instances.json")
+
+	// a server left running by the previous IM process, and one that died
+	alive := &Server{
+		Name: "lobby",
+		Dir:  t.TempDir(),
+		JVM:  fakeJava(t, "trap 'exit 0' INT\necho 'Done (1.234s)! For help, type \"help\"'\nwhile true; do sleep 0.1; done\n"),
+	}
+	if err := launch(alive); err != nil {
+		t.Fatal(err)
+	}
+	records := []instanceRecord{
+		{Name: "lobby", Port: 3000, PID: alive.Process.Pid, Dir: alive.Dir, Status: StateRunning},
+		{Name: "lunaris", Port: 3001, PID: 999999999, Dir: t.TempDir(), Status: StateRunning},
+	}
+	b, _ := json.Marshal(records)
+	if err := os.WriteFile(instanceTablePath, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := adoptInstances(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	lobby, lunaris := serverMap["lobby"], serverMap["lunaris"]
+	delete(serverMap, "lobby")
+	delete(serverMap, "lunaris")
+	mu.Unlock()
+	if lobby == nil || lobby.Status != StateRunning || lobby.Process.Pid != alive.Process.Pid {
+		t.Fatalf("lobby = %+v, want adopted and running", lobby)
+	}
+	if lunaris == nil || lunaris.Status != StateCrashed {
+		t.Errorf("lunaris = %+v, want crashed", lunaris)
+	}
+	if e := ports.entries()[3000]; e.Name != "lobby" {
+		t.Errorf("port 3000 = %+v, want owned by lobby", e)
+	}
+
+	// the adopted process has no console, so it is stopped by signal
+	if err := lobby.setState(StateStopping); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan StopResult)
+	go func() {
+		result, err := stopProcess(lobby)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- result
+	}()
+	select {
+	case result := <-done:
+		if result.Outcome != StopGraceful {
+			t.Errorf("outcome = %s, want %s", result.Outcome, StopGraceful)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("adopted process wasn't stopped")
+	}
+}
+
+func TestAdoptAfterUpdate(t *testing.T) {
+	// a node: the bootstrapper's directory holding the updated tree
+	root := t.TempDir()
+	local := filepath.Join(root, "instance_manager")
+	if err := os.MkdirAll(local, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// the IM runs inside the tree with the default paths
+	t.Chdir(local)
+	c := useTestConfig(t)
+	c.Logs.Dir = defaultConfig().Logs.Dir
+	oldPorts, oldTable := ports, instanceTablePath
+	t.Cleanup(func() { ports, instanceTablePath = oldPorts, oldTable })
+	if err := os.MkdirAll(filepath.Dir(c.PortRegistry), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if ports, err = loadPortRegistry(c.PortRegistry); err != nil {
+		t.Fatal(err)
+	}
+	instanceTablePath = c.InstanceTable
+
+	srv := &Server{
+		Name: "lobby",
+		Port: 3000,
+		Dir:  serverDir("lobby"),
+		JVM:  fakeJava(t, "echo 'Done (1.234s)! For help, type \"help\"'\nwhile true; do sleep 0.1; done\n"),
+	}
+	if err := os.MkdirAll(srv.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := launch(srv); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// not a crash
+		srv.setState(StateStopping)
+		srv.Process.Kill()
+	})
+	ports.adopt(srv.Port, srv.Name, srv.Dir)
+	mu.Lock()
+	srv.Status = StateRunning
+	serverMap["lobby"] = srv
+	saveInstanceTableLocked()
+	delete(serverMap, "lobby")
+	mu.Unlock()
+
+	// the bootstrapper stops the IM and installs v2 like the updater does:
+	// the old tree is kept in the versions directory, a new one replaces it
+	versions := filepath.Join(root, ".versions")
+	if err := os.MkdirAll(versions, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(local, filepath.Join(versions, "v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(local, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(local)
+
+	if ports, err = loadPortRegistry(c.PortRegistry); err != nil {
+		t.Fatal(err)
+	}
+	if err := ports.reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := adoptInstances(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	lobby := serverMap["lobby"]
+	delete(serverMap, "lobby")
+	mu.Unlock()
+	if lobby == nil || lobby.Status != StateRunning || lobby.Process.Pid != srv.Process.Pid {
+		t.Fatalf("lobby = %+v, want adopted and running after the update", lobby)
+	}
+	if e := ports.entries()[3000]; e.Name != "lobby" {
+		t.Errorf("port 3000 = %+v, want still owned by lobby", e)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JobPhase is the step a start job is at, as reported by /jobs/{id}.
+type JobPhase string
+
+const (
+	PhaseAllocatingPort JobPhase = "allocating_port"
+	// PhasePreparing: server.properties, paper.jar and plugins are written.
+	PhasePreparing      JobPhase = "preparing"
+	PhaseDownloading    JobPhase = "downloading_world"
+	PhaseExtracting     JobPhase = "extracting_world"
+	PhaseLaunching      JobPhase = "launching"
+	PhaseWaitingForDone JobPhase = "waiting_for_done"
+	PhaseDone           JobPhase = "done"
+	PhaseFailed         JobPhase = "failed"
+)
+
+// jobRetention is how long finished jobs can still be looked up.
+const jobRetention = 15 * time.Minute
+
+// Job tracks the asynchronous start of a server. Its fields are protected by
+// jobsMu; the methods may be called on a nil *Job and then do nothing.
+type Job struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Phase JobPhase `json:"phase"`
+	// percent of the world download, while downloading
+	Progress float64 `json:"progress,omitempty"`
+	// set once the port is allocated
+	Port    int       `json:"port,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+var (
+	jobs   = make(map[string]*Job) // id -> *Job, protected by jobsMu
+	jobsMu sync.Mutex
+)
+
+// newJob registers a job starting the server name and drops jobs that
+// finished more than jobRetention ago.
+func newJob(name string) *Job {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	now := time.Now()
+	j := &Job{ID: hex.EncodeToString(b), Name: name, Phase: PhaseAllocatingPort, Created: now, Updated: now}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for id, old := range jobs {
+		if old.finished() && now.Sub(old.Updated) > jobRetention {
+			delete(jobs, id)
+		}
+	}
+	jobs[j.ID] = j
+	return j
+}
+
+func (j *Job) finished() bool {
+	return j.Phase == PhaseDone || j.Phase == PhaseFailed
+}
+
+func (j *Job) setPhase(phase JobPhase) {
+	if j == nil {
+		return
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j.Phase = phase
+	j.Progress = 0
+	j.Updated = time.Now()
+	log.Printf("Job %s (%s): %s", j.ID, j.Name, phase)
+}
+
+func (j *Job) setProgress(percent float64) {
+	if j == nil {
+		return
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j.Progress = percent
+	j.Updated = time.Now()
+}
+
+func (j *Job) setPort(port int) {
+	if j == nil {
+		return
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j.Port = port
+}
+
+// fail ends the job with err.
+func (j *Job) fail(err error) {
+	if j == nil {
+		return
+	}
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	j.Phase = PhaseFailed
+	j.Error = err.Error()
+	j.Updated = time.Now()
+	log.Printf("Job %s (%s) failed: %v", j.ID, j.Name, err)
+}
+
+// jobHandler reports the start job {id}.
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	jobsMu.Lock()
+	j, ok := jobs[id]
+	var snapshot Job
+	if ok {
+		snapshot = *j
+	}
+	jobsMu.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("Job '%s' not found", id), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJobHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs/{id}", jobHandler)
+
+	job := newJob("lobby")
+	job.setPort(3001)
+	job.setPhase(PhaseDownloading)
+	job.setProgress(42)
+	job.fail(errors.New("world install failed"))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/jobs/"+job.ID, nil))
+	var got Job
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "lobby" || got.Port != 3001 || got.Phase != PhaseFailed || got.Error != "world install failed" {
+		t.Errorf("job = %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/jobs/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown job: status %d, want 404", rec.Code)
+	}
+}
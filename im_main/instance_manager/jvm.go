@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// JVMOptions configures the Java process of a Paper server. Template options
+// are applied on top of the global ones: set fields replace the global value,
+// except Properties, which are merged.
+type JVMOptions struct {
+	// java binary, "java" from $PATH by default
+	Java string `json:"java,omitempty"`
+	// heap size, used for both -Xms and -Xmx, e.g. "2G"
+	Memory string `json:"memory,omitempty"`
+	// extra JVM flags such as GC tuning
+	Flags []string `json:"flags,omitempty"`
+	// system properties passed as -Dkey=value
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+var memoryPattern = regexp.MustCompile(`^[1-9][0-9]*[KkMmGg]?$`)
+
+var errInsufficientMemory = errors.New("not enough free memory")
+
+// parseMemory converts a JVM size like "2G" or "512m" to bytes.
+func parseMemory(s string) (uint64, error) {
+	if !memoryPattern.MatchString(s) {
+		return 0, fmt.Errorf("%q is not a JVM size like 2G or 512M", s)
+	}
+	shift := 0
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	}
+	if shift != 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n > math.MaxUint64>>shift {
+		return 0, fmt.Errorf("%q is too large", s)
+	}
+	return n << shift, nil
+}
+
+func (o JVMOptions) validate() error {
+	if o.Memory != "" {
+		if _, err := parseMemory(o.Memory); err != nil {
+			return fmt.Errorf("memory: %w", err)
+		}
+	}
+	for _, f := range o.Flags {
+		if !strings.HasPrefix(f, "-") {
+			return fmt.Errorf("flag %q doesn't start with -", f)
+		}
+	}
+	for k := range o.Properties {
+		if k == "" || strings.ContainsAny(k, "= ") {
+			return fmt.Errorf("invalid system property name %q", k)
+		}
+	}
+	return nil
+}
+
+// merge returns o with the fields set in override applied.
+func (o JVMOptions) merge(override JVMOptions) JVMOptions {
+	if override.Java != "" {
+		o.Java = override.Java
+	}
+	if override.Memory != "" {
+		o.Memory = override.Memory
+	}
+	if override.Flags != nil {
+		o.Flags = override.Flags
+	}
+	props := make(map[string]string, len(o.Properties)+len(override.Properties))
+	for k, v := range o.Properties {
+		props[k] = v
+	}
+	for k, v := range override.Properties {
+		props[k] = v
+	}
+	o.Properties = props
+	return o
+}
+
+// args returns the java command line that runs paper.jar.
+func (o JVMOptions) args() []string {
+	java := o.Java
+	if java == "" {
+		java = "java"
+	}
+	args := []string{java, "-Xms" + o.Memory, "-Xmx" + o.Memory}
+	args = append(args, o.Flags...)
+
+	keys := make([]string, 0, len(o.Properties))
+	for k := range o.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, fmt.Sprintf("-D%s=%s", k, o.Properties[k]))
+	}
+	return append(args, "-jar", "paper.jar", "--nogui")
+}
+
+// javaCommand builds the command starting a Paper server in dir. It fails if
+// the heap is larger than the memory currently available on this machine.
+func javaCommand(dir string, o JVMOptions) (*exec.Cmd, error) {
+	if err := checkFreeMemory(o.Memory); err != nil {
+		return nil, err
+	}
+	args := o.args()
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	return cmd, nil
+}
+
+// checkFreeMemory fails if a heap of the given size doesn't fit into the
+// memory currently available on this machine.
+func checkFreeMemory(memory string) error {
+	heap, err := parseMemory(memory)
+	if err != nil {
+		return err
+	}
+	vm, err := mem.VirtualMemory()
+	if err != nil {
+		return fmt.Errorf("reading free memory: %w", err)
+	}
+	if heap > vm.Available {
+		return fmt.Errorf("%w: heap of %s exceeds the %d MB available", errInsufficientMemory, memory, vm.Available>>20)
+	}
+	return nil
+}
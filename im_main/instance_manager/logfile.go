@@ -0,0 +1,296 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// currentLogFile is the log an instance is writing to; rotated logs are named
+// console-<time>.log.gz next to it.
+const currentLogFile = "console.log"
+
+// namePattern restricts instance names, which are used as directory names.
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// LogConfig controls the per-instance console logs below Dir/<instance>.
+type LogConfig struct {
+	Dir string `json:"dir"`
+	// the current log is rotated once it is bigger or older than this;
+	// a zero max_age disables rotation by age
+	MaxSizeMB int      `json:"max_size_mb"`
+	MaxAge    Duration `json:"max_age"`
+	// rotated logs kept per instance
+	MaxFiles int `json:"max_files"`
+}
+
+func (c *LogConfig) validate() error {
+	if c.Dir == "" {
+		return errors.New("dir is empty")
+	}
+	if c.MaxSizeMB <= 0 || c.MaxFiles <= 0 {
+		return errors.New("max_size_mb and max_files must be positive")
+	}
+	if c.MaxAge < 0 {
+		return errors.New("max_age must not be negative")
+	}
+	return nil
+}
+
+// instanceLogDir returns the log directory of the instance name.
+func instanceLogDir(name string) string {
+	return filepath.Join(cfg.Logs.Dir, name)
+}
+
+// instanceLog writes the console of an instance to disk, rotating and
+// compressing it according to cfg.Logs. Its methods may be called on a nil
+// *instanceLog and then do nothing.
+type instanceLog struct {
+	mu     sync.Mutex
+	dir    string
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// openInstanceLog opens the current log of the instance name for appending.
+func openInstanceLog(name string) (*instanceLog, error) {
+	l := &instanceLog{dir: instanceLogDir(name)}
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return nil, err
+	}
+	// a log left over from an earlier run counts from its last write
+	if info, err := os.Stat(filepath.Join(l.dir, currentLogFile)); err == nil && l.expired(info.Size(), info.ModTime()) {
+		if err := l.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *instanceLog) open() error {
+	f, err := os.OpenFile(filepath.Join(l.dir, currentLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+func (l *instanceLog) expired(size int64, opened time.Time) bool {
+	if size >= int64(cfg.Logs.MaxSizeMB)<<20 {
+		return true
+	}
+	return size > 0 && cfg.Logs.MaxAge > 0 && time.Since(opened) >= time.Duration(cfg.Logs.MaxAge)
+}
+
+// writeLine appends line to the log, rotating it first if it is due.
+func (l *instanceLog) writeLine(line string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return
+	}
+	if l.expired(l.size, l.opened) {
+		l.f.Close()
+		l.f = nil
+		if err := l.rotate(); err != nil {
+			log.Printf("Failed to rotate log in %s: %v", l.dir, err)
+		}
+		if err := l.open(); err != nil {
+			log.Printf("Failed to reopen log in %s: %v", l.dir, err)
+			return
+		}
+	}
+	n, err := fmt.Fprintln(l.f, line)
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("Failed to write log in %s: %v", l.dir, err)
+	}
+}
+
+// rotate moves the current log aside, compresses it and drops the oldest
+// rotated logs beyond cfg.Logs.MaxFiles. The current log must be closed.
+func (l *instanceLog) rotate() error {
+	src := filepath.Join(l.dir, currentLogFile)
+	dst := filepath.Join(l.dir, fmt.Sprintf("console-%s.log", time.Now().Format("20060102-150405.000")))
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	if err := gzipFile(dst); err != nil {
+		return err
+	}
+	return pruneLogs(l.dir, cfg.Logs.MaxFiles)
+}
+
+// close closes the current log; later writes are dropped.
+func (l *instanceLog) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+	}
+}
+
+// gzipFile replaces path by path.gz.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// LogFile is an entry of the /logs listing.
+type LogFile struct {
+	File     string    `json:"file"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// listLogs returns the logs in dir, newest first.
+func listLogs(dir string) ([]LogFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []LogFile
+	for _, e := range entries {
+		if e.IsDir() || !(e.Name() == currentLogFile || strings.HasPrefix(e.Name(), "console-")) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, LogFile{File: e.Name(), Size: info.Size(), Modified: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Modified.After(files[j].Modified) })
+	return files, nil
+}
+
+// pruneLogs removes the oldest rotated logs in dir beyond keep.
+func pruneLogs(dir string, keep int) error {
+	files, err := listLogs(dir)
+	if err != nil {
+		return err
+	}
+	kept := 0
+	for _, f := range files {
+		if f.File == currentLogFile {
+			continue
+		}
+		if kept++; kept > keep {
+			if err := os.Remove(filepath.Join(dir, f.File)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// logDirFor returns the log directory of the instance ?name=, which may have
+// stopped already, or writes an error response and returns "".
+func logDirFor(w http.ResponseWriter, r *http.Request) string {
+	name := r.URL.Query().Get("name")
+	if !namePattern.MatchString(name) {
+		http.Error(w, "Missing or invalid 'name' query parameter", http.StatusBadRequest)
+		return ""
+	}
+	dir := instanceLogDir(name)
+	if _, err := os.Stat(dir); err != nil {
+		http.Error(w, fmt.Sprintf("No logs for '%s'", name), http.StatusNotFound)
+		return ""
+	}
+	return dir
+}
+
+// logsHandler lists the logs of an instance, newest first.
+func logsHandler(w http.ResponseWriter, r *http.Request) {
+	dir := logDirFor(w, r)
+	if dir == "" {
+		return
+	}
+	files, err := listLogs(dir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+// logDownloadHandler sends the log ?file= of an instance.
+func logDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	dir := logDirFor(w, r)
+	if dir == "" {
+		return
+	}
+	file := r.URL.Query().Get("file")
+	if file == "" || filepath.Base(file) != file || !(file == currentLogFile || strings.HasPrefix(file, "console-")) {
+		http.Error(w, "Missing or invalid 'file' query parameter", http.StatusBadRequest)
+		return
+	}
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Log '%s' not found", file), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	contentType := "text/plain; charset=utf-8"
+	if strings.HasSuffix(file, ".gz") {
+		contentType = "application/gzip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(dir)+"-"+file))
+	http.ServeContent(w, r, file, info.ModTime(), f)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInstanceLogRotation(t *testing.T) {
+	c := useTestConfig(t)
+	c.Logs.MaxSizeMB = 1
+	c.Logs.MaxFiles = 2
+
+	l, err := openInstanceLog("lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1 MiB per round, so each round after the first starts with a rotation
+	line := strings.Repeat("x", 1023)
+	for round := 0; round < 4; round++ {
+		for i := 0; i < 1024; i++ {
+			l.writeLine(line)
+		}
+	}
+	l.close()
+
+	files, err := listLogs(instanceLogDir("lobby"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rotated []string
+	for _, f := range files {
+		if f.File != currentLogFile {
+			rotated = append(rotated, f.File)
+		}
+	}
+	if len(files) != 3 || len(rotated) != 2 {
+		t.Fatalf("logs = %+v, want the current log and 2 rotated ones", files)
+	}
+
+	f, err := os.Open(filepath.Join(instanceLogDir("lobby"), rotated[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 1<<20 {
+		t.Errorf("rotated log has %d bytes, want %d", len(b), 1<<20)
+	}
+}
+
+func TestOutputRetriesLogLater(t *testing.T) {
+	c := useTestConfig(t)
+	// a file where the log directory should be
+	c.Logs.Dir = filepath.Join(t.TempDir(), "logs")
+	if err := os.WriteFile(c.Logs.Dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var logged strings.Builder
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	srv := &Server{Name: "lobby"}
+	for i := 0; i < 3; i++ {
+		srv.output("line")
+	}
+	if n := strings.Count(logged.String(), "Failed to open log"); n != 1 {
+		t.Errorf("open failure logged %d times, want once:\n%s", n, logged.String())
+	}
+
+	// once the delay is over and the directory is usable, lines are logged
+	if err := os.Remove(c.Logs.Dir); err != nil {
+		t.Fatal(err)
+	}
+	srv.output("not logged")
+	mu.Lock()
+	srv.logRetry = time.Now()
+	mu.Unlock()
+	srv.output("logged")
+	srv.logFile.close()
+	b, err := os.ReadFile(filepath.Join(instanceLogDir("lobby"), currentLogFile))
+	if err != nil || !strings.Contains(string(b), "logged") || strings.Contains(string(b), "not logged") {
+		t.Errorf("log = %q, %v", b, err)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+var errNoFreePort = errors.New("no free port")
+
+// PortEntry is a port in use according to the registry.
+type PortEntry struct {
+	Name string `json:"name"`
+	Dir  string `json:"dir"`
+	// the server wasn't started by this IM process but found running at
+	// startup
+	Orphan bool `json:"orphan,omitempty"`
+}
+
+// portRegistry hands out the ports of cfg.PortBase..cfg.PortMax and keeps
+// the assignments in a file so they survive restarts of the IM.
+type portRegistry struct {
+	mu    sync.Mutex
+	path  string
+	Ports map[int]PortEntry `json:"ports"`
+}
+
+var ports *portRegistry // set at startup
+
+// loadPortRegistry reads the registry at path; a missing file is empty.
+func loadPortRegistry(path string) (*portRegistry, error) {
+	r := &portRegistry{path: path, Ports: make(map[int]PortEntry)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if r.Ports == nil {
+		r.Ports = make(map[int]PortEntry)
+	}
+	return r, nil
+}
+
+// saveLocked writes the registry; r.mu must be held.
+func (r *portRegistry) saveLocked() error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// allocate assigns the lowest port of the range that is neither registered
+// nor bound by another program to the server name.
+func (r *portRegistry) allocate(name string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for p := cfg.PortBase; p <= cfg.PortMax; p++ {
+		if _, used := r.Ports[p]; used || !portFree(p) {
+			continue
+		}
+		r.Ports[p] = PortEntry{Name: name, Dir: serverDir(name)}
+		if err := r.saveLocked(); err != nil {
+			delete(r.Ports, p)
+			return 0, fmt.Errorf("saving port registry: %w", err)
+		}
+		return p, nil
+	}
+	return 0, fmt.Errorf("%w in %d-%d", errNoFreePort, cfg.PortBase, cfg.PortMax)
+}
+
+// adopt records that the server name, found running in dir, owns port.
+func (r *portRegistry) adopt(port int, name, dir string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Ports[port] = PortEntry{Name: name, Dir: dir}
+	if err := r.saveLocked(); err != nil {
+		log.Printf("Failed to save port registry: %v", err)
+	}
+}
+
+// release returns port to the pool.
+func (r *portRegistry) release(port int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.Ports[port]; !ok {
+		return
+	}
+	delete(r.Ports, port)
+	if err := r.saveLocked(); err != nil {
+		log.Printf("Failed to save port registry: %v", err)
+	}
+}
+
+// entries returns a copy of the registered ports.
+func (r *portRegistry) entries() map[int]PortEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := make(map[int]PortEntry, len(r.Ports))
+	for p, e := range r.Ports {
+		m[p] = e
+	}
+	return m
+}
+
+// reconcile brings the registry in line with what is running: ports whose
+// server directory has no Java process any more are freed, and Java
+// processes found in server directories keep their port as orphans.
+// Registered ports outside the configured range are kept with a warning.
+func (r *portRegistry) reconcile() error {
+	running, err := javaProcessDirs()
+	if err != nil {
+		return fmt.Errorf("listing Java processes: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for p, e := range r.Ports {
+		switch {
+		case running[absDir(e.Dir)]:
+			e.Orphan = true
+			r.Ports[p] = e
+			log.Printf("Port %d: server '%s' is still running in %s", p, e.Name, e.Dir)
+		default:
+			delete(r.Ports, p)
+			log.Printf("Port %d: server '%s' is gone, releasing the port", p, e.Name)
+		}
+	}
+
+	// server directories the registry didn't know about
+	dirs, err := serverDirs()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		p, ok := dirPort(dir)
+		if !ok {
+			continue
+		}
+		if _, known := r.Ports[p]; !known && running[absDir(dir)] {
+			r.Ports[p] = PortEntry{Dir: dir, Orphan: true}
+			log.Printf("Port %d: unregistered server is running in %s", p, dir)
+		}
+	}
+
+	for p, e := range r.Ports {
+		if p < cfg.PortBase || p > cfg.PortMax {
+			log.Printf("Warning: port %d of %s is outside %d-%d", p, e.Dir, cfg.PortBase, cfg.PortMax)
+		}
+	}
+	return r.saveLocked()
+}
+
+// portFree reports whether port can be bound on all interfaces, as Paper
+// does.
+func portFree(port int) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// absDir returns dir as a clean absolute path, for comparing it with the
+// working directories of processes.
+func absDir(dir string) string {
+	if abs, err := filepath.Abs(dir); err == nil {
+		return abs
+	}
+	return filepath.Clean(dir)
+}
+
+// javaDir returns the working directory of p if it is a Java process.
+func javaDir(p *process.Process) (string, bool) {
+	name, err := p.Name()
+	if err != nil || !strings.Contains(name, "java") {
+		return "", false
+	}
+	cwd, err := p.Cwd()
+	if err != nil {
+		return "", false
+	}
+	return filepath.Clean(cwd), true
+}
+
+// javaProcessDirs returns the absolute working directories of the running
+// Java processes.
+func javaProcessDirs() (map[string]bool, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]bool)
+	for _, p := range procs {
+		if dir, ok := javaDir(p); ok {
+			dirs[dir] = true
+		}
+	}
+	return dirs, nil
+}
+
+// javaRunningIn reports whether pid is a Java process working in dir, so a
+// recycled PID isn't mistaken for a server.
+func javaRunningIn(pid int, dir string) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := process.NewProcess(int32(pid))
+	if err != nil {
+		return false
+	}
+	cwd, ok := javaDir(p)
+	return ok && cwd == absDir(dir)
+}
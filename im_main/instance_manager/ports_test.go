@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestPortRegistry(t *testing.T) {
+	c := useTestConfig(t)
+	// a range of two ports whose first one is taken by another program
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c.PortBase = l.Addr().(*net.TCPAddr).Port
+	c.PortMax = c.PortBase + 1
+
+	path := filepath.Join(t.TempDir(), "ports.json")
+	r, err := loadPortRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := r.allocate("lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != c.PortMax {
+		t.Errorf("allocated %d, want %d", port, c.PortMax)
+	}
+	if _, err := r.allocate("lunaris"); !errors.Is(err, errNoFreePort) {
+		t.Errorf("allocating from a full range: err = %v", err)
+	}
+
+	// the assignment survives a restart, until reconciling finds no server
+	r, err = loadPortRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := r.entries()[port]; e.Name != "lobby" || e.Dir != serverDir("lobby") {
+		t.Errorf("reloaded entry = %+v", e)
+	}
+	if err := r.reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.entries()) != 0 {
+		t.Errorf("entries after reconcile = %+v, want none", r.entries())
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Restart modes of a RestartPolicy.
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure" // only after a non-zero exit code
+	RestartAlways    = "always"
+)
+
+// stableRun is how long a server has to stay up for its restart count and
+// backoff to be reset.
+const stableRun = 5 * time.Minute
+
+// crashLogLines is how many console lines are kept when a server crashes.
+const crashLogLines = 50
+
+// crashHandlers counts the handleCrash calls in progress.
+var crashHandlers sync.WaitGroup
+
+// RestartPolicy says what happens when a server exits while it is running.
+type RestartPolicy struct {
+	Mode string `json:"mode"`
+	// wait before the first restart, doubled after every further one
+	Backoff    Duration `json:"backoff"`
+	MaxBackoff Duration `json:"max_backoff"`
+	// restarts in a row before giving up; 0 means no limit
+	MaxRestarts int `json:"max_restarts"`
+}
+
+func (p *RestartPolicy) validate() error {
+	switch p.Mode {
+	case RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("mode %q is not one of %s, %s, %s", p.Mode, RestartNever, RestartOnFailure, RestartAlways)
+	}
+	if p.Backoff < 0 || p.MaxBackoff < p.Backoff {
+		return fmt.Errorf("backoff %s and max_backoff %s must satisfy 0 <= backoff <= max_backoff",
+			time.Duration(p.Backoff), time.Duration(p.MaxBackoff))
+	}
+	if p.MaxRestarts < 0 {
+		return fmt.Errorf("max_restarts %d is negative", p.MaxRestarts)
+	}
+	return nil
+}
+
+// shouldRestart reports whether a server that exited with code after
+// restarts restarts in a row is started again.
+func (p *RestartPolicy) shouldRestart(code, restarts int) bool {
+	if p.MaxRestarts > 0 && restarts >= p.MaxRestarts {
+		return false
+	}
+	switch p.Mode {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return code != 0
+	}
+	return false
+}
+
+// delay returns the wait before restart number n, counted from 0.
+func (p *RestartPolicy) delay(n int) time.Duration {
+	d := time.Duration(p.Backoff)
+	for ; n > 0 && d < time.Duration(p.MaxBackoff); n-- {
+		d *= 2
+	}
+	return min(d, time.Duration(p.MaxBackoff))
+}
+
+// handleCrash is called when proc, the process of srv, exited with code (-1
+// if unknown) while srv was running. It marks srv crashed, tells the server
+// manager and restarts srv as long as its template's restart policy allows;
+// then it releases the port of srv.
+func handleCrash(srv *Server, proc *os.Process, code int) {
+	defer crashHandlers.Done()
+	mu.Lock()
+	if srv.Process != proc || srv.Status != StateRunning {
+		// stopped or restarted by a handler in the meantime
+		mu.Unlock()
+		return
+	}
+	_ = srv.setStateLocked(StateCrashed)
+	srv.ExitCode = &code
+	srv.LastLog = srv.console.last(crashLogLines)
+	if time.Since(srv.startedAt) >= stableRun {
+		srv.restarts = 0
+	}
+	mu.Unlock()
+	log.Printf("Server '%s' crashed with exit code %d:\n%s", srv.Name, code, strings.Join(srv.LastLog, "\n"))
+	notifyServerManager(srv.Name, StateCrashed, &code)
+
+	policy := cfg.Restart
+	if tmpl, err := cfg.template(srv.Name); err == nil {
+		policy = tmpl.restartPolicy()
+	}
+	for {
+		mu.Lock()
+		n := srv.restarts
+		mu.Unlock()
+		if !policy.shouldRestart(code, n) {
+			log.Printf("Server '%s' is not restarted (policy %s, %d restarts)", srv.Name, policy.Mode, n)
+			releaseCrashedPort(srv)
+			return
+		}
+		wait := policy.delay(n)
+		log.Printf("Restarting server '%s' in %s (restart %d)", srv.Name, wait, n+1)
+		time.Sleep(wait)
+
+		mu.Lock()
+		if serverMap[srv.Name] != srv {
+			mu.Unlock()
+			return
+		}
+		if err := srv.setStateLocked(StateRestarting); err != nil {
+			// stopped by a handler during the backoff
+			mu.Unlock()
+			return
+		}
+		srv.restarts++
+		mu.Unlock()
+
+		if err := relaunch(srv); err != nil {
+			log.Printf("Restarting server '%s' failed: %v", srv.Name, err)
+			continue
+		}
+		notifyServerManager(srv.Name, StateRunning, nil)
+		return
+	}
+}
+
+// releaseCrashedPort returns the port of srv to the pool once no restart
+// follows its crash. The crashed entry stays, with its exit code and last
+// log, but without a port, like one that ended while the IM was down.
+func releaseCrashedPort(srv *Server) {
+	mu.Lock()
+	if serverMap[srv.Name] != srv || srv.Status != StateCrashed {
+		mu.Unlock()
+		return
+	}
+	port := srv.Port
+	srv.Port = 0
+	saveInstanceTableLocked()
+	mu.Unlock()
+	ports.release(port)
+}
+
+// notifyServerManager tells the server manager that the server name changed
+// to status on its own, so it can update the proxy. Failures are only logged.
+func notifyServerManager(name string, status State, exitCode *int) {
+	if cfg.ServerManagerURL == "" {
+		return
+	}
+	eventURL := strings.TrimRight(cfg.ServerManagerURL, "/") + "/instance-event"
+	body, _ := json.Marshal(map[string]any{
+		"name":      name,
+		"status":    status,
+		"exit_code": exitCode,
+	})
+	go func() {
+		client := &http.Client{Timeout: 5 * time.Second}
+		resp, err := client.Post(eventURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Failed to notify server manager about '%s': %v", name, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Server manager answered %s to the event of '%s'", resp.Status, name)
+		}
+	}()
+}
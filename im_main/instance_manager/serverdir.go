@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// legacyDirPrefix names the directories of older IM versions,
+// paper_server_<port>, which are only cleaned up.
+const legacyDirPrefix = "paper_server_"
+
+// gcInterval is how often unused server directories are collected.
+const gcInterval = time.Hour
+
+// ServerDirConfig says where server directories live and how long the
+// directory of a stopped server is kept.
+type ServerDirConfig struct {
+	Dir string `json:"dir"`
+	// a stopped server's directory is removed once unused for this long
+	Retention Duration `json:"retention"`
+}
+
+func (c *ServerDirConfig) validate() error {
+	if c.Dir == "" {
+		return errors.New("dir is empty")
+	}
+	if c.Retention < 0 {
+		return errors.New("retention must not be negative")
+	}
+	return nil
+}
+
+// serverDir returns the directory of the instance name.
+func serverDir(name string) string {
+	return filepath.Join(cfg.Servers.Dir, name)
+}
+
+// serverDirs returns the existing server directories, current and legacy.
+func serverDirs() ([]string, error) {
+	var dirs []string
+	entries, err := os.ReadDir(cfg.Servers.Dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			dirs = append(dirs, filepath.Join(cfg.Servers.Dir, e.Name()))
+		}
+	}
+	legacy, err := filepath.Glob(legacyDirPrefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	return append(dirs, legacy...), nil
+}
+
+// dirPort returns the server-port in the server.properties of dir.
+func dirPort(dir string) (int, bool) {
+	f, err := os.Open(filepath.Join(dir, "server.properties"))
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(scanner.Text(), "server-port="); ok {
+			port, err := strconv.Atoi(strings.TrimSpace(v))
+			return port, err == nil
+		}
+	}
+	return 0, false
+}
+
+// touchServerDir marks dir as used now, which restarts its retention.
+func touchServerDir(dir string) {
+	if dir == "" {
+		return
+	}
+	now := time.Now()
+	if err := os.Chtimes(dir, now, now); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Failed to touch %s: %v", dir, err)
+	}
+}
+
+// collectServerDirs removes the directories of servers that are neither
+// registered nor running and haven't been used for olderThan. With dryRun it
+// only returns what it would remove.
+func collectServerDirs(olderThan time.Duration, dryRun bool) ([]string, error) {
+	dirs, err := serverDirs()
+	if err != nil {
+		return nil, err
+	}
+	running, err := javaProcessDirs()
+	if err != nil {
+		return nil, fmt.Errorf("listing Java processes: %w", err)
+	}
+
+	inUse := make(map[string]bool)
+	mu.Lock()
+	for _, srv := range serverMap {
+		inUse[absDir(srv.Dir)] = true
+	}
+	mu.Unlock()
+	for _, e := range ports.entries() {
+		inUse[absDir(e.Dir)] = true
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	removed := []string{}
+	for _, dir := range dirs {
+		abs := absDir(dir)
+		if inUse[abs] || running[abs] {
+			continue
+		}
+		info, err := os.Stat(dir)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if !dryRun {
+			if err := os.RemoveAll(dir); err != nil {
+				return removed, fmt.Errorf("removing %s: %w", dir, err)
+			}
+			log.Printf("Removed unused server directory %s", dir)
+		}
+		removed = append(removed, dir)
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// collectServerDirsPeriodically runs collectServerDirs with the configured
+// retention every gcInterval.
+func collectServerDirsPeriodically() {
+	for {
+		if _, err := collectServerDirs(time.Duration(cfg.Servers.Retention), false); err != nil {
+			log.Printf("Collecting server directories failed: %v", err)
+		}
+		time.Sleep(gcInterval)
+	}
+}
+
+// gcHandler removes unused server directories now. ?older_than= overrides
+// the configured retention, ?dry_run=true only lists them.
+func gcHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	olderThan := time.Duration(cfg.Servers.Retention)
+	if v := r.URL.Query().Get("older_than"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "Invalid 'older_than' query parameter", http.StatusBadRequest)
+			return
+		}
+		olderThan = d
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	removed, err := collectServerDirs(olderThan, dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"removed": removed,
+		"dry_run": dryRun,
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCollectServerDirs(t *testing.T) {
+	c := useTestConfig(t)
+	c.Servers.Dir = t.TempDir()
+	oldPorts := ports
+	t.Cleanup(func() { ports = oldPorts })
+	var err error
+	if ports, err = loadPortRegistry(filepath.Join(t.TempDir(), "ports.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"stale", "recent", "crashed"} {
+		if err := os.MkdirAll(filepath.Join(serverDir(name), "world"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if name != "recent" {
+			if err := os.Chtimes(serverDir(name), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// a crashed server still owns its directory
+	mu.Lock()
+	serverMap["crashed"] = &Server{Name: "crashed", Dir: serverDir("crashed"), Status: StateCrashed}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(serverMap, "crashed")
+		mu.Unlock()
+	})
+
+	want := []string{serverDir("stale")}
+	removed, err := collectServerDirs(24*time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(removed, want) {
+		t.Errorf("dry run removed %q, want %q", removed, want)
+	}
+	if _, err := os.Stat(serverDir("stale")); err != nil {
+		t.Errorf("dry run deleted the directory: %v", err)
+	}
+
+	if removed, err = collectServerDirs(24*time.Hour, false); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(removed, want) {
+		t.Errorf("removed %q, want %q", removed, want)
+	}
+	for name, exists := range map[string]bool{"stale": false, "recent": true, "crashed": true} {
+		if _, err := os.Stat(serverDir(name)); (err == nil) != exists {
+			t.Errorf("%s exists: %t, want %t", name, err == nil, exists)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot triggers.
+const (
+	TriggerManual    = "manual"
+	TriggerCleanup   = "cleanup"
+	TriggerScheduled = "scheduled"
+)
+
+// snapshotDir is the world store directory of the snapshots of an instance,
+// snapshots/<name>/, each a <id>.zip with a <id>.json describing it.
+func snapshotDir(name string) string {
+	return "snapshots/" + name + "/"
+}
+
+// snapshotIDFormat names snapshots after their UTC creation time, so they
+// sort by age.
+const snapshotIDFormat = "20060102T150405.000Z"
+
+var errSnapshotNotFound = errors.New("snapshot not found")
+
+// Snapshot is a saved world of an instance.
+type Snapshot struct {
+	ID       string `json:"id"`
+	Instance string `json:"instance"`
+	// world zip in the world store
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	SHA256  string    `json:"sha256"`
+	Trigger string    `json:"trigger"`
+	Created time.Time `json:"created"`
+}
+
+// SnapshotConfig is the retention of snapshots, per instance: the newest
+// KeepLast are kept, plus the newest of each of the last KeepDaily days and
+// KeepWeekly weeks that have one.
+type SnapshotConfig struct {
+	KeepLast   int `json:"keep_last"`
+	KeepDaily  int `json:"keep_daily"`
+	KeepWeekly int `json:"keep_weekly"`
+}
+
+func (c *SnapshotConfig) validate() error {
+	if c.KeepLast < 1 {
+		return errors.New("keep_last must be at least 1")
+	}
+	if c.KeepDaily < 0 || c.KeepWeekly < 0 {
+		return errors.New("keep_daily and keep_weekly must not be negative")
+	}
+	return nil
+}
+
+func validTrigger(trigger string) bool {
+	switch trigger {
+	case TriggerManual, TriggerCleanup, TriggerScheduled:
+		return true
+	}
+	return false
+}
+
+// fileSHA256 returns the size and hex SHA-256 of the file at p.
+func fileSHA256(p string) (int64, string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// createSnapshot stores the world zip at zipPath as a new snapshot of the
+// instance name and then applies the retention.
+func createSnapshot(name, zipPath, trigger string) (*Snapshot, error) {
+	size, sum, err := fileSHA256(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	id := now.Format(snapshotIDFormat)
+	snap := &Snapshot{
+		ID:       id,
+		Instance: name,
+		Key:      snapshotDir(name) + id + ".zip",
+		Size:     size,
+		SHA256:   sum,
+		Trigger:  trigger,
+		Created:  now,
+	}
+	if err := worlds.Put(snap.Key, zipPath); err != nil {
+		return nil, fmt.Errorf("uploading snapshot: %w", err)
+	}
+
+	// the metadata goes last: a snapshot without it isn't listed
+	meta, err := os.CreateTemp("", fmt.Sprintf("%s-*.json", name))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(meta.Name())
+	err = json.NewEncoder(meta).Encode(snap)
+	if cerr := meta.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = worlds.Put(snapshotDir(name)+id+".json", meta.Name())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("uploading snapshot metadata: %w", err)
+	}
+	log.Printf("Created snapshot %s of '%s' (%d bytes, %s)", id, name, size, trigger)
+
+	if _, err := pruneSnapshots(name); err != nil {
+		log.Printf("Pruning snapshots of '%s' failed: %v", name, err)
+	}
+	return snap, nil
+}
+
+// listSnapshots returns the snapshots of the instance name, newest first.
+func listSnapshots(name string) ([]Snapshot, error) {
+	keys, err := worlds.List(snapshotDir(name))
+	if err != nil {
+		return nil, err
+	}
+	snaps := []Snapshot{}
+	for _, key := range keys {
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := worlds.Get(key, &buf, nil); err != nil {
+			return nil, err
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(buf.Bytes(), &snap); err != nil {
+			log.Printf("Skipping snapshot metadata %s: %v", key, err)
+			continue
+		}
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Created.After(snaps[j].Created) })
+	return snaps, nil
+}
+
+// expiredSnapshots returns the snapshots of snaps, newest first, that the
+// retention c doesn't keep.
+func expiredSnapshots(snaps []Snapshot, c SnapshotConfig) []Snapshot {
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	var expired []Snapshot
+	for i, snap := range snaps {
+		keep := i < c.KeepLast
+
+		day := snap.Created.UTC().Format("2006-01-02")
+		if !days[day] && len(days) < c.KeepDaily {
+			days[day] = true
+			keep = true
+		}
+		year, week := snap.Created.UTC().ISOWeek()
+		wk := fmt.Sprintf("%d-%02d", year, week)
+		if !weeks[wk] && len(weeks) < c.KeepWeekly {
+			weeks[wk] = true
+			keep = true
+		}
+
+		if !keep {
+			expired = append(expired, snap)
+		}
+	}
+	return expired
+}
+
+// pruneSnapshots deletes the snapshots of the instance name that the
+// configured retention doesn't keep and returns them.
+func pruneSnapshots(name string) ([]Snapshot, error) {
+	snaps, err := listSnapshots(name)
+	if err != nil {
+		return nil, err
+	}
+	expired := expiredSnapshots(snaps, cfg.Snapshots)
+	for i, snap := range expired {
+		// metadata first, so a half deleted snapshot isn't listed
+		if err := worlds.Delete(snapshotDir(name) + snap.ID + ".json"); err != nil {
+			return expired[:i], err
+		}
+		if err := worlds.Delete(snap.Key); err != nil {
+			return expired[:i], err
+		}
+		log.Printf("Deleted snapshot %s of '%s'", snap.ID, name)
+	}
+	return expired, nil
+}
+
+// restoreSnapshot makes snapshot id the world of the instance name, which it
+// gets on its next start, after checking the snapshot's checksum.
+func restoreSnapshot(name, id string) (*Snapshot, error) {
+	snaps, err := listSnapshots(name)
+	if err != nil {
+		return nil, err
+	}
+	var snap *Snapshot
+	for i := range snaps {
+		if snaps[i].ID == id {
+			snap = &snaps[i]
+		}
+	}
+	if snap == nil {
+		return nil, fmt.Errorf("%w: %s of '%s'", errSnapshotNotFound, id, name)
+	}
+	tmpl, err := cfg.template(name)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", fmt.Sprintf("%s-*.zip", name))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	err = worlds.Get(snap.Key, io.MultiWriter(tmp, h), nil)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("downloading snapshot: %w", err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != snap.SHA256 {
+		return nil, fmt.Errorf("snapshot %s is corrupt: checksum %s, want %s", id, sum, snap.SHA256)
+	}
+
+	if err := putCopy(path.Clean(tmpl.WorldFile(name)), snap.Key, tmp.Name()); err != nil {
+		return nil, fmt.Errorf("uploading world: %w", err)
+	}
+	log.Printf("Restored snapshot %s of '%s' for its next start", id, name)
+	return snap, nil
+}
+
+// snapshotsHandler lists the snapshots of instance ?name=, newest first.
+func snapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if !namePattern.MatchString(name) {
+		http.Error(w, "Invalid 'name' query parameter", http.StatusBadRequest)
+		return
+	}
+	snaps, err := listSnapshots(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snaps)
+}
+
+// restoreSnapshotHandler restores snapshot ?id= of instance ?name=. The
+// instance must not be running, as it would save over the restored world.
+func restoreSnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name, id := r.URL.Query().Get("name"), r.URL.Query().Get("id")
+	if !namePattern.MatchString(name) || id == "" {
+		http.Error(w, "Invalid 'name' or missing 'id' query parameter", http.StatusBadRequest)
+		return
+	}
+	mu.Lock()
+	var state State
+	if srv, ok := serverMap[name]; ok {
+		state = srv.Status
+	}
+	mu.Unlock()
+	if state != "" && state != StateStopped && state != StateCrashed {
+		http.Error(w, fmt.Sprintf("Server '%s' is %s; stop it before restoring a snapshot", name, state), http.StatusConflict)
+		return
+	}
+
+	snap, err := restoreSnapshot(name, id)
+	if errors.Is(err, errSnapshotNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snap)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpiredSnapshots(t *testing.T) {
+	// one snapshot every 12 hours for four weeks, newest first
+	start := time.Date(2026, 3, 1, 6, 0, 0, 0, time.UTC)
+	var snaps []Snapshot
+	for i := 55; i >= 0; i-- {
+		created := start.Add(time.Duration(i) * 12 * time.Hour)
+		snaps = append(snaps, Snapshot{ID: created.Format(snapshotIDFormat), Created: created})
+	}
+
+	expired := expiredSnapshots(snaps, SnapshotConfig{KeepLast: 3, KeepDaily: 4, KeepWeekly: 3})
+	kept := make(map[string]bool)
+	for _, s := range snaps {
+		kept[s.ID] = true
+	}
+	for _, s := range expired {
+		delete(kept, s.ID)
+	}
+
+	want := map[string]bool{
+		// last 3
+		"20260328T180000.000Z": true, "20260328T060000.000Z": true, "20260327T180000.000Z": true,
+		// newest of the last 4 days, two of them already kept
+		"20260326T180000.000Z": true, "20260325T180000.000Z": true,
+		// newest of the last 3 ISO weeks, their Sundays; 2026-W13 is kept already
+		"20260322T180000.000Z": true, "20260315T180000.000Z": true,
+	}
+	for id := range want {
+		if !kept[id] {
+			t.Errorf("%s expired, want kept", id)
+		}
+	}
+	for id := range kept {
+		if !want[id] {
+			t.Errorf("%s kept, want expired", id)
+		}
+	}
+}
+
+// useTestWorlds makes a local directory the world store for the test.
+func useTestWorlds(t *testing.T) *localStore {
+	t.Helper()
+	old := worlds
+	store := &localStore{dir: t.TempDir()}
+	worlds = store
+	t.Cleanup(func() { worlds = old })
+	return store
+}
+
+func TestSnapshots(t *testing.T) {
+	c := useTestConfig(t)
+	c.Snapshots = SnapshotConfig{KeepLast: 2}
+	store := useTestWorlds(t)
+
+	zipPath := filepath.Join(t.TempDir(), "world.zip")
+	var ids []string
+	for _, content := range []string{"monday", "tuesday", "wednesday"} {
+		if err := os.WriteFile(zipPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		snap, err := createSnapshot("lobby", zipPath, TriggerCleanup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snap.Size != int64(len(content)) || snap.Trigger != TriggerCleanup || len(snap.SHA256) != 64 {
+			t.Errorf("snapshot = %+v", snap)
+		}
+		ids = append(ids, snap.ID)
+		time.Sleep(2 * time.Millisecond) // distinct IDs
+	}
+
+	// the oldest was pruned
+	snaps, err := listSnapshots("lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 2 || snaps[0].ID != ids[2] || snaps[1].ID != ids[1] {
+		t.Fatalf("snapshots = %+v, want %v", snaps, ids[1:])
+	}
+	if _, err := os.Stat(store.path(snapshotDir("lobby") + ids[0] + ".zip")); !os.IsNotExist(err) {
+		t.Errorf("pruned snapshot zip: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/snapshots", snapshotsHandler)
+	mux.HandleFunc("/snapshots/restore", restoreSnapshotHandler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/snapshots?name=lobby", nil))
+	var listed []Snapshot
+	if err := json.NewDecoder(rec.Body).Decode(&listed); err != nil || len(listed) != 2 {
+		t.Fatalf("GET /snapshots = %d %v %+v", rec.Code, err, listed)
+	}
+
+	// restoring makes the snapshot the world the next start downloads
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/snapshots/restore?name=lobby&id="+ids[1], nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("restore = %d %s", rec.Code, rec.Body)
+	}
+	if b, err := os.ReadFile(store.path("lobby.zip")); err != nil || string(b) != "tuesday" {
+		t.Errorf("lobby.zip = %q, %v", b, err)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/snapshots/restore?name=lobby&id="+ids[0], nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("restore pruned = %d, want 404", rec.Code)
+	}
+
+	// not while the server runs
+	mu.Lock()
+	serverMap["lobby"] = &Server{Name: "lobby", Status: StateRunning}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(serverMap, "lobby")
+		mu.Unlock()
+	})
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/snapshots/restore?name=lobby&id="+ids[2], nil))
+	if rec.Code != http.StatusConflict {
+		t.Errorf("restore while running = %d, want 409", rec.Code)
+	}
+
+	// a corrupt snapshot isn't restored
+	if err := os.WriteFile(store.path(snaps[0].Key), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	serverMap["lobby"].Status = StateStopped
+	mu.Unlock()
+	if _, err := restoreSnapshot("lobby", ids[2]); err == nil || errors.Is(err, errSnapshotNotFound) {
+		t.Errorf("restoring a corrupt snapshot = %v", err)
+	}
+}
+
+// countingStore records the uploads to a local store.
+type countingStore struct {
+	*localStore
+	puts []string
+}
+
+func (s *countingStore) Put(key, localPath string) error {
+	s.puts = append(s.puts, key)
+	return s.localStore.Put(key, localPath)
+}
+
+func TestSaveWorldUploadsOnce(t *testing.T) {
+	useTestConfig(t)
+	store := &countingStore{localStore: useTestWorlds(t)}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "world"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "world", "level.dat"), []byte("level"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the store copies the snapshot to the world file
+	worlds = store
+	snap, err := saveWorld("lobby", dir, TriggerManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{snap.Key, snapshotDir("lobby") + snap.ID + ".json"}; strings.Join(store.puts, " ") != strings.Join(want, " ") {
+		t.Errorf("uploads = %v, want %v", store.puts, want)
+	}
+	world, err := os.ReadFile(store.path("lobby.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(world); hex.EncodeToString(sum[:]) != snap.SHA256 {
+		t.Error("lobby.zip isn't the snapshot")
+	}
+
+	// one that can't uploads the world file as well
+	store.puts = nil
+	worlds = struct{ WorldStore }{store}
+	if _, err := saveWorld("lobby", dir, TriggerManual); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.puts) != 3 || store.puts[2] != "lobby.zip" {
+		t.Errorf("uploads without copy = %v", store.puts)
+	}
+}
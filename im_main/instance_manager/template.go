@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Template describes a type of server, e.g. the lobby or the per-player
+// asteroids. An instance uses the first template whose pattern matches its
+// name. "{name}" in World, WorldFallback and PluginConfigs is replaced by the
+// instance name.
+type Template struct {
+	Name string `json:"name"`
+	// glob matched against instance names, e.g. "lunaris_asteroid_*"
+	Pattern string `json:"pattern"`
+	// world zip in the world store, also where saves go
+	World string `json:"world"`
+	// world installed when World can't be downloaded; empty fails the start
+	WorldFallback string `json:"world_fallback,omitempty"`
+	// local directory copied to the server's plugins folder
+	Plugins string `json:"plugins"`
+	// Java settings on top of the global ones
+	JVM JVMOptions `json:"jvm,omitempty"`
+	// replaces the global restart policy
+	Restart *RestartPolicy `json:"restart,omitempty"`
+	// server.properties entries on top of the defaults
+	Properties map[string]string `json:"properties,omitempty"`
+	// files written below the plugins folder, path -> content
+	PluginConfigs map[string]string `json:"plugin_configs,omitempty"`
+	// save the world before the server manager stops an empty server
+	SaveOnStop bool `json:"save_on_stop,omitempty"`
+	// keep the server running even without players
+	Persistent bool `json:"persistent,omitempty"`
+}
+
+// TemplateInfo is the part of a template the server manager needs.
+type TemplateInfo struct {
+	Name       string `json:"name"`
+	Pattern    string `json:"pattern"`
+	SaveOnStop bool   `json:"save_on_stop,omitempty"`
+	Persistent bool   `json:"persistent,omitempty"`
+}
+
+func (t *Template) info() TemplateInfo {
+	return TemplateInfo{Name: t.Name, Pattern: t.Pattern, SaveOnStop: t.SaveOnStop, Persistent: t.Persistent}
+}
+
+func (t *Template) expand(s, name string) string {
+	return strings.ReplaceAll(s, "{name}", name)
+}
+
+// WorldFile returns the world zip of the instance name.
+func (t *Template) WorldFile(name string) string { return t.expand(t.World, name) }
+
+// FallbackWorldFile returns the fallback world zip, or "" if there is none.
+func (t *Template) FallbackWorldFile(name string) string { return t.expand(t.WorldFallback, name) }
+
+// jvm returns the Java settings of the template's servers.
+func (t *Template) jvm() JVMOptions {
+	return cfg.JVM.merge(t.JVM)
+}
+
+// restartPolicy returns the restart policy of the template's servers.
+func (t *Template) restartPolicy() RestartPolicy {
+	if t.Restart != nil {
+		return *t.Restart
+	}
+	return cfg.Restart
+}
+
+// serverProperties returns the contents of server.properties for an instance
+// listening on port.
+func (t *Template) serverProperties(port int) string {
+	props := map[string]string{
+		"motd":                 fmt.Sprintf("Dynamic Paper Server %d", port),
+		"enable-command-block": "true",
+		"online-mode":          "false",
+	}
+	for k, v := range t.Properties {
+		props[k] = v
+	}
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "server-port=%d\n", port)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", k, props[k])
+	}
+	return b.String()
+}
+
+func (t *Template) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("name is empty")
+	}
+	if _, err := path.Match(t.Pattern, ""); err != nil || t.Pattern == "" {
+		return fmt.Errorf("%s: invalid pattern %q", t.Name, t.Pattern)
+	}
+	if t.World == "" || t.Plugins == "" {
+		return fmt.Errorf("%s: world and plugins are required", t.Name)
+	}
+	if err := t.JVM.validate(); err != nil {
+		return fmt.Errorf("%s: jvm: %w", t.Name, err)
+	}
+	if t.Restart != nil {
+		if err := t.Restart.validate(); err != nil {
+			return fmt.Errorf("%s: restart: %w", t.Name, err)
+		}
+	}
+	for k := range t.Properties {
+		if k == "server-port" {
+			return fmt.Errorf("%s: server-port is assigned by the instance manager", t.Name)
+		}
+	}
+	for p := range t.PluginConfigs {
+		if !filepath.IsLocal(p) {
+			return fmt.Errorf("%s: plugin config %q must be a relative path inside the plugins folder", t.Name, p)
+		}
+	}
+	return nil
+}
+
+// template returns the template for the instance name.
+func (c *Config) template(name string) (*Template, error) {
+	for i := range c.Templates {
+		if ok, _ := path.Match(c.Templates[i].Pattern, name); ok {
+			return &c.Templates[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no server template matches %q", name)
+}
@@ -0,0 +1,687 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// World store backends.
+const (
+	WorldStoreGitHub = "github"
+	WorldStoreLocal  = "local"
+	WorldStoreS3     = "s3"
+)
+
+// githubMaxWorld is the largest file the GitHub contents API accepts.
+const githubMaxWorld = 100 << 20
+
+var errWorldNotFound = errors.New("world not found")
+
+// WorldStore keeps the world zips, addressed by keys like "lobby.zip" or
+// "lunaris_asteroid/lunaris_asteroid_bob.zip".
+type WorldStore interface {
+	// Get writes the world key to w. progress, if not nil, is told the
+	// percentage done when the size is known and the download is logged.
+	Get(key string, w io.Writer, progress func(float64)) error
+	// Put stores the file at localPath as the world key.
+	Put(key, localPath string) error
+	// List returns the keys of the worlds directly in dir, like
+	// "snapshots/lobby/"; a missing dir is empty.
+	List(dir string) ([]string, error)
+	// Delete removes the world key; a missing one isn't an error.
+	Delete(key string) error
+}
+
+var worlds WorldStore // set at startup
+
+// worldCopier is implemented by the world stores that can copy a world
+// without it being uploaded again.
+type worldCopier interface {
+	// Copy stores the world src as the world dst too.
+	Copy(src, dst string) error
+}
+
+// putCopy stores the file at localPath, which is also the world src, as the
+// world dst: copied by the store where it can, uploaded again otherwise.
+func putCopy(dst, src, localPath string) error {
+	if c, ok := worlds.(worldCopier); ok {
+		return c.Copy(src, dst)
+	}
+	return worlds.Put(dst, localPath)
+}
+
+// WorldStoreConfig selects and configures the world store. The github
+// backend uses worlds_repo and worlds_branch.
+type WorldStoreConfig struct {
+	Backend string `json:"backend"`
+	// local: directory, possibly an NFS mount, holding the zips
+	Dir string `json:"dir,omitempty"`
+	// s3: bucket of an S3-compatible service such as MinIO
+	S3 S3Config `json:"s3"`
+}
+
+// S3Config addresses a bucket. Objects are requested path-style,
+// {endpoint}/{bucket}/{prefix}{key}, which AWS and MinIO both serve.
+type S3Config struct {
+	Endpoint  string `json:"endpoint,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"secret_key,omitempty"`
+}
+
+func (c *WorldStoreConfig) validate() error {
+	switch c.Backend {
+	case WorldStoreGitHub:
+	case WorldStoreLocal:
+		if c.Dir == "" {
+			return errors.New("dir is empty")
+		}
+	case WorldStoreS3:
+		u, err := url.Parse(c.S3.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("s3.endpoint: %q is not an http(s) URL", c.S3.Endpoint)
+		}
+		if c.S3.Bucket == "" || c.S3.Region == "" {
+			return errors.New("s3.bucket and s3.region must be set")
+		}
+		if c.S3.AccessKey == "" || c.S3.SecretKey == "" {
+			return errors.New("s3.access_key and s3.secret_key must be set")
+		}
+	default:
+		return fmt.Errorf("unknown backend %q", c.Backend)
+	}
+	return nil
+}
+
+// newWorldStore returns the store configured in c. githubToken is only
+// needed by the github backend.
+func newWorldStore(c *Config, githubToken string) (WorldStore, error) {
+	switch c.WorldStore.Backend {
+	case WorldStoreGitHub:
+		if githubToken == "" {
+			return nil, errors.New("GITHUB_TOKEN not found in environment")
+		}
+		return &githubStore{repo: c.WorldsRepo, branch: c.WorldsBranch, token: githubToken}, nil
+	case WorldStoreLocal:
+		return &localStore{dir: c.WorldStore.Dir}, nil
+	case WorldStoreS3:
+		return &s3Store{cfg: c.WorldStore.S3, client: &http.Client{}}, nil
+	}
+	return nil, fmt.Errorf("unknown world store backend %q", c.WorldStore.Backend)
+}
+
+// copyProgress copies src to dst. With a progress func it logs the progress
+// every second and reports it to progress when total is known.
+func copyProgress(dst io.Writer, src io.Reader, total int64, progress func(float64)) error {
+	if progress == nil {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	var done int64
+	buf := make([]byte, 32*1024)
+
+	start := time.Now()
+	lastPrint := time.Now()
+
+	fmt.Println("[World] Downloading...")
+
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, wErr := dst.Write(buf[:n]); wErr != nil {
+				return wErr
+			}
+			done += int64(n)
+		}
+
+		if time.Since(lastPrint) >= time.Second {
+			speed := float64(done) / time.Since(start).Seconds() / 1024 / 1024
+			if total > 0 {
+				percent := float64(done) / float64(total) * 100
+				fmt.Printf("[World] %.1f%% (%.2f MB/s)\n", percent, speed)
+				progress(percent)
+			} else {
+				fmt.Printf("[World] %.1f MB (%.2f MB/s)\n", float64(done)/1024/1024, speed)
+			}
+			lastPrint = time.Now()
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	progress(100)
+	fmt.Printf("[World] Download finished in %s\n", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// githubStore keeps the worlds in a GitHub repository: downloads come from
+// raw.githubusercontent.com, uploads go through the contents API.
+type githubStore struct {
+	repo, branch, token string
+}
+
+func (s *githubStore) Get(key string, w io.Writer, progress func(float64)) error {
+	url := fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s", s.repo, s.branch, key)
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", errWorldNotFound, key)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitHub returned %s for %s", resp.Status, key)
+	}
+	return copyProgress(w, resp.Body, resp.ContentLength, progress)
+}
+
+// contentsURL returns the contents API URL of key, or of the directory key.
+func (s *githubStore) contentsURL(key string) string {
+	// only escape the path, NOT owner/repo
+	return fmt.Sprintf("https://api.github.com/repos/%s/contents/%s", s.repo, s3Escape(key, true))
+}
+
+// do sends a contents API request with body as JSON, if not nil, and returns
+// the status code and response body.
+func (s *githubStore) do(method, url string, body any) (int, []byte, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Authorization", "token "+strings.TrimSpace(s.token)) // IMPORTANT → matches curl exactly
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "github-upload")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s request failed: %w", method, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, respBody, nil
+}
+
+// sha returns the blob SHA of key, which updates and deletes need, or "" if
+// key doesn't exist.
+func (s *githubStore) sha(key string) (string, error) {
+	status, body, err := s.do(http.MethodGet, s.contentsURL(key)+"?ref="+url.QueryEscape(s.branch), nil)
+	if err != nil {
+		return "", err
+	}
+	switch status {
+	case http.StatusOK:
+		var info struct {
+			SHA string `json:"sha"`
+		}
+		if err := json.Unmarshal(body, &info); err != nil {
+			return "", fmt.Errorf("failed to parse GET response: %w", err)
+		}
+		if info.SHA == "" {
+			return "", fmt.Errorf("github returned no sha for existing file")
+		}
+		return info.SHA, nil
+	case http.StatusNotFound:
+		return "", nil
+	}
+	return "", fmt.Errorf("GitHub GET returned %d: %s", status, string(body))
+}
+
+func (s *githubStore) Put(key, localPath string) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read local file: %w", err)
+	}
+	if info.Size() > githubMaxWorld {
+		return fmt.Errorf("%s is %d MB, more than the GitHub contents API takes; use the local or s3 world store",
+			key, info.Size()>>20)
+	}
+	content, err := os.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read local file: %w", err)
+	}
+
+	sha, err := s.sha(key)
+	if err != nil {
+		return err
+	}
+	reqBody := map[string]string{
+		"message": fmt.Sprintf("Save world %s at %s", key, time.Now().UTC().Format(time.RFC3339)),
+		"content": base64.StdEncoding.EncodeToString(content),
+		"branch":  s.branch,
+	}
+	if sha != "" {
+		reqBody["sha"] = sha
+	}
+	status, body, err := s.do(http.MethodPut, s.contentsURL(key), reqBody)
+	if err != nil {
+		return err
+	}
+	// Expect 200 (update) or 201 (create)
+	if status != http.StatusOK && status != http.StatusCreated {
+		return fmt.Errorf("GitHub PUT %d: %s", status, string(body))
+	}
+	return nil
+}
+
+func (s *githubStore) List(dir string) ([]string, error) {
+	status, body, err := s.do(http.MethodGet, s.contentsURL(strings.TrimSuffix(dir, "/"))+"?ref="+url.QueryEscape(s.branch), nil)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("GitHub GET returned %d: %s", status, string(body))
+	}
+	var entries []struct {
+		Path string `json:"path"`
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse GET response: %w", err)
+	}
+	var keys []string
+	for _, e := range entries {
+		if e.Type == "file" {
+			keys = append(keys, e.Path)
+		}
+	}
+	return keys, nil
+}
+
+func (s *githubStore) Delete(key string) error {
+	sha, err := s.sha(key)
+	if err != nil || sha == "" {
+		return err
+	}
+	status, body, err := s.do(http.MethodDelete, s.contentsURL(key), map[string]string{
+		"message": fmt.Sprintf("Delete %s", key),
+		"sha":     sha,
+		"branch":  s.branch,
+	})
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusNotFound {
+		return fmt.Errorf("GitHub DELETE %d: %s", status, string(body))
+	}
+	return nil
+}
+
+// localStore keeps the worlds as files below a directory, which may be an NFS
+// mount shared by several IMs.
+type localStore struct {
+	dir string
+}
+
+// path returns the file of key, which can't leave s.dir.
+func (s *localStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func (s *localStore) Get(key string, w io.Writer, progress func(float64)) error {
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", errWorldNotFound, key)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
+	}
+	return copyProgress(w, f, size, progress)
+}
+
+// Put copies the file next to its destination first, so readers never see a
+// partly written world.
+func (s *localStore) Put(key, localPath string) error {
+	dst := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp := dst + ".tmp"
+	if err := copyFile(localPath, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// Copy copies within the directory, which an NFS 4.2 server does on its side.
+func (s *localStore) Copy(src, dst string) error {
+	if _, err := os.Stat(s.path(src)); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", errWorldNotFound, src)
+	}
+	return s.Put(dst, s.path(src))
+}
+
+func (s *localStore) List(dir string) ([]string, error) {
+	entries, err := os.ReadDir(s.path(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasSuffix(e.Name(), ".tmp") {
+			keys = append(keys, path.Join(dir, e.Name()))
+		}
+	}
+	return keys, nil
+}
+
+func (s *localStore) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// s3Store keeps the worlds in a bucket of an S3-compatible service, signing
+// its requests with AWS Signature Version 4. Objects are uploaded with a
+// single PUT, which S3 takes up to 5GB.
+type s3Store struct {
+	cfg    S3Config
+	client *http.Client
+}
+
+// emptySHA256 is the hex SHA-256 of an empty payload.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func (s *s3Store) objectURL(key string) string {
+	return strings.TrimRight(s.cfg.Endpoint, "/") + "/" + s3Escape(s.cfg.Bucket, true) + "/" + s3Escape(s.cfg.Prefix+key, true)
+}
+
+func (s *s3Store) Get(key string, w io.Writer, progress func(float64)) error {
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, emptySHA256)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", errWorldNotFound, key)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("S3 GET %s returned %s: %s", key, resp.Status, bytes.TrimSpace(body))
+	}
+	return copyProgress(w, resp.Body, resp.ContentLength, progress)
+}
+
+func (s *s3Store) Put(key, localPath string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the payload hash is signed, so the file is read twice
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(key), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	s.sign(req, hex.EncodeToString(h.Sum(nil)))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("S3 PUT %s returned %s: %s", key, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// Copy has the service copy the object (CopyObject), which takes up to 5GB
+// like a single PUT.
+func (s *s3Store) Copy(src, dst string) error {
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(dst), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Amz-Copy-Source", "/"+s3Escape(s.cfg.Bucket+"/"+s.cfg.Prefix+src, true))
+	s.sign(req, emptySHA256)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// a copy failing after it started still answers 200, with an error body
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", errWorldNotFound, src)
+	}
+	if resp.StatusCode != http.StatusOK || bytes.Contains(body, []byte("<Error>")) {
+		return fmt.Errorf("S3 copy %s to %s returned %s: %s", src, dst, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func (s *s3Store) List(dir string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		params := []string{"delimiter=%2F", "list-type=2", "prefix=" + s3Escape(s.cfg.Prefix+dir, false)}
+		if token != "" {
+			params = append([]string{"continuation-token=" + s3Escape(token, false)}, params...)
+		}
+		req, err := http.NewRequest(http.MethodGet, strings.TrimRight(s.cfg.Endpoint, "/")+"/"+s3Escape(s.cfg.Bucket, true)+"?"+strings.Join(params, "&"), nil)
+		if err != nil {
+			return nil, err
+		}
+		s.sign(req, emptySHA256)
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("S3 list %s returned %s: %s", dir, resp.Status, bytes.TrimSpace(body))
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("parsing S3 list: %w", err)
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(c.Key, s.cfg.Prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3Store) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, emptySHA256)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// S3 answers 204 whether or not the object existed
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("S3 DELETE %s returned %s: %s", key, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// sign adds the Signature Version 4 headers for a payload with the hex
+// SHA-256 payloadHash to req. Headers set later aren't signed.
+func (s *s3Store) sign(req *http.Request, payloadHash string) {
+	amzDate := time.Now().UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := s3Headers(req)
+	signature := s3Signature(s.cfg.SecretKey, s.cfg.Region, req.Method, req.URL.EscapedPath(),
+		req.URL.Query(), headers, payloadHash, amzDate)
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, s3Scope(amzDate, s.cfg.Region), s3SignedHeaders(headers), signature))
+}
+
+// s3Headers returns the headers of req the signature covers, the host and
+// every x-amz-* header, by lowercase name.
+func s3Headers(req *http.Request) map[string]string {
+	headers := map[string]string{"host": req.Host}
+	for name, vs := range req.Header {
+		if name := strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(vs, ","))
+		}
+	}
+	return headers
+}
+
+// s3SignedHeaders returns the sorted names of headers joined by ';'.
+func s3SignedHeaders(headers map[string]string) string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ";")
+}
+
+func s3Scope(amzDate, region string) string {
+	return amzDate[:8] + "/" + region + "/s3/aws4_request"
+}
+
+// s3Signature returns the Signature Version 4 of a request to the S3 service.
+func s3Signature(secret, region, method, escapedPath string, query url.Values, headers map[string]string, payloadHash, amzDate string) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		vs := append([]string(nil), query[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			params = append(params, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	if escapedPath == "" {
+		escapedPath = "/"
+	}
+
+	signed := s3SignedHeaders(headers)
+	var canonicalHeaders strings.Builder
+	for _, name := range strings.Split(signed, ";") {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	canonical := strings.Join([]string{
+		method,
+		escapedPath,
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + s3Scope(amzDate, region) + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, and '/'
+// in paths, as S3 expects in canonical requests.
+func s3Escape(s string, path bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && path {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testWorldStore checks that a world put into s comes back unchanged and that
+// a missing one is reported as not found.
+func testWorldStore(t *testing.T, s WorldStore) {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "world.zip")
+	content := bytes.Repeat([]byte("lunexia world "), 5000)
+	if err := os.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("lunaris_asteroid/lunaris_asteroid_bob.zip", src); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	var got bytes.Buffer
+	var progress float64
+	if err := s.Get("lunaris_asteroid/lunaris_asteroid_bob.zip", &got, func(p float64) { progress = p }); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), content) {
+		t.Errorf("Get returned %d bytes, want %d", got.Len(), len(content))
+	}
+	if progress != 100 {
+		t.Errorf("progress = %v, want 100", progress)
+	}
+
+	if err := s.Get("missing.zip", io.Discard, nil); !errors.Is(err, errWorldNotFound) {
+		t.Errorf("Get missing = %v, want errWorldNotFound", err)
+	}
+
+	if c, ok := s.(worldCopier); ok {
+		if err := c.Copy("lunaris_asteroid/lunaris_asteroid_bob.zip", "lobby.zip"); err != nil {
+			t.Fatalf("Copy: %v", err)
+		}
+		got.Reset()
+		if err := s.Get("lobby.zip", &got, nil); err != nil || !bytes.Equal(got.Bytes(), content) {
+			t.Errorf("Get copy = %d bytes, %v; want %d", got.Len(), err, len(content))
+		}
+		if err := c.Copy("missing.zip", "lobby.zip"); !errors.Is(err, errWorldNotFound) {
+			t.Errorf("Copy missing = %v, want errWorldNotFound", err)
+		}
+	}
+
+	if err := s.Put("lunaris_asteroid/lunaris_asteroid_amy.zip", src); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	keys, err := s.List("lunaris_asteroid/")
+	sort.Strings(keys)
+	if err != nil || strings.Join(keys, " ") != "lunaris_asteroid/lunaris_asteroid_amy.zip lunaris_asteroid/lunaris_asteroid_bob.zip" {
+		t.Errorf("List = %v, %v", keys, err)
+	}
+	if keys, err := s.List("missing/"); err != nil || len(keys) != 0 {
+		t.Errorf("List missing = %v, %v", keys, err)
+	}
+	if err := s.Delete("lunaris_asteroid/lunaris_asteroid_amy.zip"); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	if err := s.Delete("missing.zip"); err != nil {
+		t.Errorf("Delete missing: %v", err)
+	}
+	if keys, err := s.List("lunaris_asteroid/"); err != nil || len(keys) != 1 {
+		t.Errorf("List after Delete = %v, %v", keys, err)
+	}
+}
+
+func TestLocalWorldStore(t *testing.T) {
+	dir := t.TempDir()
+	testWorldStore(t, &localStore{dir: dir})
+	if _, err := os.Stat(filepath.Join(dir, "lunaris_asteroid", "lunaris_asteroid_bob.zip")); err != nil {
+		t.Error(err)
+	}
+	// keys can't leave the directory
+	if got := (&localStore{dir: dir}).path("../../etc/passwd"); got != filepath.Join(dir, "etc", "passwd") {
+		t.Errorf("path = %s", got)
+	}
+}
+
+// fakeS3 is a minimal S3 stand-in that checks the request signatures.
+type fakeS3 struct {
+	t       *testing.T
+	secret  string
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+var authPattern = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=([^/]+)/(\d{8})/([^/]+)/s3/aws4_request, SignedHeaders=([^,]+), Signature=([0-9a-f]{64})$`)
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m := authPattern.FindStringSubmatch(r.Header.Get("Authorization"))
+	amzDate := r.Header.Get("X-Amz-Date")
+	headers := s3Headers(r)
+	if m == nil || m[1] != "minio" || m[4] != s3SignedHeaders(headers) || !strings.HasPrefix(amzDate, m[2]) {
+		f.t.Errorf("%s %s: bad Authorization %q", r.Method, r.URL, r.Header.Get("Authorization"))
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	want := s3Signature(f.secret, m[3], r.Method, r.URL.EscapedPath(), r.URL.Query(), headers, payloadHash, amzDate)
+	if m[5] != want {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+			src, _ = url.PathUnescape(src)
+			body, ok := f.objects[src]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			f.objects[r.URL.Path] = body
+			w.Write([]byte("<CopyObjectResult></CopyObjectResult>"))
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != payloadHash {
+			http.Error(w, "XAmzContentSHA256Mismatch", http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Path] = body
+	case http.MethodGet:
+		if r.URL.Query().Get("list-type") == "2" {
+			f.list(w, r)
+			return
+		}
+		body, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "MethodNotAllowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// list answers a ListObjectsV2 request with a delimiter of "/", one key per
+// page to exercise the continuation.
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	bucket := r.URL.Path + "/"
+	var keys []string
+	for p := range f.objects {
+		key, ok := strings.CutPrefix(p, bucket+q.Get("prefix"))
+		if ok && !strings.Contains(key, "/") {
+			keys = append(keys, strings.TrimPrefix(p, bucket))
+		}
+	}
+	sort.Strings(keys)
+	if token := q.Get("continuation-token"); token != "" {
+		i := sort.SearchStrings(keys, token)
+		keys = keys[i:]
+	}
+
+	type contents struct {
+		Key string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []contents
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}{}
+	if len(keys) > 0 {
+		result.Contents = []contents{{keys[0]}}
+	}
+	if len(keys) > 1 {
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[1]
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+func TestS3WorldStore(t *testing.T) {
+	fake := &fakeS3{t: t, secret: "minio-secret", objects: make(map[string][]byte)}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	s := &s3Store{
+		cfg: S3Config{
+			Endpoint:  ts.URL,
+			Region:    "us-east-1",
+			Bucket:    "worlds",
+			Prefix:    "lunexia/",
+			AccessKey: "minio",
+			SecretKey: "minio-secret",
+		},
+		client: ts.Client(),
+	}
+	testWorldStore(t, s)
+	if _, ok := fake.objects["/worlds/lunexia/lunaris_asteroid/lunaris_asteroid_bob.zip"]; !ok {
+		t.Errorf("objects = %v", fake.objects)
+	}
+
+	// a wrong secret is refused
+	s.cfg.SecretKey = "wrong"
+	if err := s.Get("lunaris_asteroid/lunaris_asteroid_bob.zip", io.Discard, nil); err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("Get with wrong secret = %v", err)
+	}
+}
+
+func TestS3Escape(t *testing.T) {
+	if got := s3Escape("worlds/a b+c~.zip", true); got != "worlds/a%20b%2Bc~.zip" {
+		t.Errorf("path = %s", got)
+	}
+	if got := s3Escape("a/b", false); got != "a%2Fb" {
+		t.Errorf("query = %s", got)
+	}
+}
+
+// TestS3Signature checks the signer against the GET bucket example of the AWS
+// Signature Version 4 documentation.
+func TestS3Signature(t *testing.T) {
+	got := s3Signature("wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY", "us-east-1", "GET", "/",
+		url.Values{"max-keys": {"2"}, "prefix": {"J"}},
+		map[string]string{"host": "examplebucket.s3.amazonaws.com", "x-amz-content-sha256": emptySHA256, "x-amz-date": "20130524T000000Z"},
+		emptySHA256, "20130524T000000Z")
+	if want := "34b48302e7b5fa45bde8084f4b7868a86f0a534bc59db6670ed5711ef69dc6f7"; got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+}
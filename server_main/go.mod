@@ -2,4 +2,9 @@ module foo/bar
 
 go 1.25.4
 
-require github.com/joho/godotenv v1.5.1 // direct
+require (
+	foo/updater v0.0.0
+	github.com/joho/godotenv v1.5.1 // direct
+)
+
+replace foo/updater => ../updater
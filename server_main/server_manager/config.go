@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 2 * time.Second
+
+// Duration is a time.Duration written as a string like "15s" in the config.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"15s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// CommandConfig is an external program started by the server manager.
+type CommandConfig struct {
+	Dir     string   `json:"dir"`
+	Command []string `json:"command"`
+}
+
+// Config is the server manager configuration. A loaded Config is never
+// modified; changes go through a copy that replaces it.
+type Config struct {
+	// address the HTTP API listens on; changes need a restart
+	Listen string `json:"listen"`
+	// admin API of the Velocity proxy plugin
+	ProxyAdminURL string `json:"proxy_admin_url"`
+
+	// server that is always kept running and never cleaned up
+	LobbyServer     string   `json:"lobby_server"`
+	LobbyDelay      Duration `json:"lobby_delay"`
+	LobbyInterval   Duration `json:"lobby_interval"`
+	CleanupDelay    Duration `json:"cleanup_delay"`
+	CleanupInterval Duration `json:"cleanup_interval"`
+	// how long an IM may take to start a server, world download included
+	StartTimeout Duration `json:"start_timeout"`
+	// how long an IM may take to stop a server, or to save and restart it
+	StopTimeout Duration `json:"stop_timeout"`
+
+	// server name or template name -> IMs its servers should preferably run
+	// on; a server's own name goes before its template's
+	PreferredIMs map[string][]string `json:"preferred_ims"`
+
+	Velocity CommandConfig `json:"velocity"`
+	// directory of the dashboard, started with npm; empty disables it
+	WebsiteDir string `json:"website_dir"`
+
+	InstanceManagers []ConfigIM `json:"instance_managers"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Listen:          ":8080",
+		ProxyAdminURL:   "http://localhost:8081",
+		LobbyServer:     "lobby",
+		LobbyDelay:      Duration(10 * time.Second),
+		LobbyInterval:   Duration(15 * time.Second),
+		CleanupDelay:    Duration(7 * time.Second),
+		CleanupInterval: Duration(60 * time.Second),
+		StartTimeout:    Duration(10 * time.Minute),
+		StopTimeout:     Duration(3 * time.Minute),
+		PreferredIMs:    map[string][]string{},
+		Velocity: CommandConfig{
+			Dir:     "./proxy",
+			Command: []string{"java", "-jar", "velocity.jar"},
+		},
+		WebsiteDir: "./website",
+	}
+}
+
+// Validate reports the first problem with c.
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	u, err := url.Parse(c.ProxyAdminURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("proxy_admin_url: %q is not an http(s) URL", c.ProxyAdminURL)
+	}
+	if c.LobbyServer == "" {
+		return errors.New("lobby_server is empty")
+	}
+	for name, d := range map[string]Duration{"lobby_interval": c.LobbyInterval, "cleanup_interval": c.CleanupInterval, "start_timeout": c.StartTimeout, "stop_timeout": c.StopTimeout} {
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+	if c.LobbyDelay < 0 || c.CleanupDelay < 0 {
+		return errors.New("lobby_delay and cleanup_delay must not be negative")
+	}
+	if len(c.Velocity.Command) == 0 || c.Velocity.Command[0] == "" {
+		return errors.New("velocity.command is empty")
+	}
+	for serverType, names := range c.PreferredIMs {
+		for _, name := range names {
+			if name == "" {
+				return fmt.Errorf("preferred_ims[%q] contains an empty name", serverType)
+			}
+		}
+	}
+	seen := make(map[string]bool)
+	for i, im := range c.InstanceManagers {
+		if im.Name == "" || im.Domain == "" {
+			return fmt.Errorf("instance_managers[%d]: name and domain are required", i)
+		}
+		if seen[im.Name] {
+			return fmt.Errorf("instance_managers: duplicate name %q", im.Name)
+		}
+		seen[im.Name] = true
+	}
+	return nil
+}
+
+// proxyURL returns the proxy admin endpoint path, e.g. "/status".
+func (c *Config) proxyURL(path string) string {
+	return c.ProxyAdminURL + path
+}
+
+// selfURL returns path on the server manager's own API.
+func (c *Config) selfURL(path string) string {
+	host, port, _ := net.SplitHostPort(c.Listen)
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + path
+}
+
+var (
+	configPath    = "../server_manager.json"
+	cfgMu         sync.RWMutex
+	cfg           *Config
+	configModTime time.Time
+)
+
+// currentConfig returns the active configuration. Callers must not modify it.
+func currentConfig() *Config {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	return cfg
+}
+
+// readConfigFile parses and validates the config file. Missing fields keep
+// their defaults.
+func readConfigFile(path string) (*Config, time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	c := defaultConfig()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, time.Time{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return &c, st.ModTime(), nil
+}
+
+// loadConfig reads the config at startup. A missing file is created from the
+// instance managers of a legacy ims_config.json; without one there is nothing
+// to run servers on, so the server manager stops.
+func loadConfig() {
+	c, modTime, err := readConfigFile(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		var legacy string
+		if c, legacy, err = legacyConfig(); err != nil {
+			log.Fatalf("%s not found and nothing to migrate (%v); copy server_manager.example.json there and list the instance managers", configPath, err)
+		}
+		log.Printf("%s not found, migrating the instance managers of %s", configPath, legacy)
+		if modTime, err = writeConfigFile(c); err != nil {
+			log.Printf("Failed to write config file: %v", err)
+		}
+	} else if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	applyConfig(c, modTime)
+}
+
+// legacyIMsFiles are where versions before the config file listed the
+// instance managers: ims_config.json in the tree, which an update moves to
+// the bootstrapper's versions directory.
+var legacyIMsFiles = []string{"ims_config.json", "../.versions/*/ims_config.json"}
+
+// legacyConfig returns the defaults with the instance managers of the newest
+// legacy ims_config.json, and that file. Without one the error matches
+// os.ErrNotExist.
+func legacyConfig() (*Config, string, error) {
+	var newest string
+	var newestMod time.Time
+	for _, pattern := range legacyIMsFiles {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, m := range matches {
+			if st, err := os.Stat(m); err == nil && (newest == "" || st.ModTime().After(newestMod)) {
+				newest, newestMod = m, st.ModTime()
+			}
+		}
+	}
+	if newest == "" {
+		return nil, "", fmt.Errorf("no ims_config.json: %w", os.ErrNotExist)
+	}
+
+	b, err := os.ReadFile(newest)
+	if err != nil {
+		return nil, "", err
+	}
+	c := defaultConfig()
+	if err := json.Unmarshal(b, &c.InstanceManagers); err != nil {
+		return nil, "", fmt.Errorf("parsing %s: %w", newest, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, "", fmt.Errorf("invalid %s: %w", newest, err)
+	}
+	return &c, newest, nil
+}
+
+// reloadConfig re-reads the config file. An invalid file is logged and the
+// running configuration is kept.
+func reloadConfig() {
+	c, modTime, err := readConfigFile(configPath)
+	if err != nil {
+		log.Printf("Config reload failed, keeping the current config: %v", err)
+		// don't retry until the file changes again
+		if st, statErr := os.Stat(configPath); statErr == nil {
+			cfgMu.Lock()
+			configModTime = st.ModTime()
+			cfgMu.Unlock()
+		}
+		return
+	}
+	if old := currentConfig(); old != nil && old.Listen != c.Listen {
+		log.Printf("Config: listen changed to %s, takes effect after a restart", c.Listen)
+	}
+	applyConfig(c, modTime)
+	log.Printf("Config reloaded from %s", configPath)
+}
+
+func applyConfig(c *Config, modTime time.Time) {
+	cfgMu.Lock()
+	cfg = c
+	configModTime = modTime
+	cfgMu.Unlock()
+
+	ims := make([]InstanceManager, 0, len(c.InstanceManagers))
+	for _, im := range c.InstanceManagers {
+		ims = append(ims, InstanceManager{Domain: im.Domain, Name: im.Name})
+	}
+	mu.Lock()
+	instanceManagers = ims
+	mu.Unlock()
+}
+
+func writeConfigFile(c *Config) (time.Time, error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return time.Time{}, err
+	}
+	tmp := configPath + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return time.Time{}, err
+	}
+	if err := os.Rename(tmp, configPath); err != nil {
+		return time.Time{}, err
+	}
+	st, err := os.Stat(configPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return st.ModTime(), nil
+}
+
+// watchConfig reloads the config on SIGHUP and whenever the file's
+// modification time changes.
+func watchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			log.Printf("SIGHUP received, reloading %s", configPath)
+			reloadConfig()
+		case <-ticker.C:
+			st, err := os.Stat(configPath)
+			if err != nil {
+				continue
+			}
+			cfgMu.RLock()
+			changed := !st.ModTime().Equal(configModTime)
+			cfgMu.RUnlock()
+			if changed {
+				reloadConfig()
+			}
+		}
+	}
+}
+
+// configHandler serves the active configuration read-only.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(currentConfig()); err != nil {
+		http.Error(w, "failed to encode config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
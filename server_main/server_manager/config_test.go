@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadConfigFileDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"cleanup_interval": "5m", "instance_managers": [{"domain": "10.0.0.2:8000", "name": "a"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, _, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(c.CleanupInterval) != 5*time.Minute {
+		t.Errorf("cleanup_interval = %v, want 5m", time.Duration(c.CleanupInterval))
+	}
+	if c.Listen != ":8080" || c.LobbyServer != "lobby" {
+		t.Errorf("defaults not kept: listen %q, lobby %q", c.Listen, c.LobbyServer)
+	}
+	if got := c.selfURL("/status"); got != "http://localhost:8080/status" {
+		t.Errorf("selfURL = %q", got)
+	}
+}
+
+func TestReadConfigFileInvalid(t *testing.T) {
+	cases := map[string]string{
+		"unknown field":   `{"lisen": ":8080"}`,
+		"bad listen":      `{"listen": "8080"}`,
+		"bad proxy url":   `{"proxy_admin_url": "localhost:8081"}`,
+		"zero interval":   `{"lobby_interval": "0s"}`,
+		"number duration": `{"lobby_interval": 15}`,
+		"empty velocity":  `{"velocity": {"dir": "./proxy", "command": []}}`,
+		"duplicate im":    `{"instance_managers": [{"domain": "a:1", "name": "x"}, {"domain": "b:1", "name": "x"}]}`,
+	}
+	for name, body := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := readConfigFile(path); err == nil || !strings.Contains(err.Error(), path) {
+				t.Fatalf("err = %v, want an error naming the file", err)
+			}
+		})
+	}
+}
+
+func TestLegacyConfig(t *testing.T) {
+	root := t.TempDir()
+	tree := filepath.Join(root, "server_manager")
+	if err := os.MkdirAll(tree, 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(tree)
+	if _, _, err := legacyConfig(); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("legacyConfig without a file = %v, want ErrNotExist", err)
+	}
+
+	// two trees kept by the updater, the newer one listing both IMs
+	old := time.Now().Add(-time.Hour)
+	for version, ims := range map[string]string{
+		"v1": `[{"domain": "172.30.0.3:8000", "name": "Ju PC"}]`,
+		"v2": `[{"domain": "172.30.0.3:8000", "name": "Ju PC"}, {"domain": "172.30.0.2:8000", "name": "Ju Server"}]`,
+	} {
+		p := filepath.Join(root, ".versions", version, "ims_config.json")
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(ims), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if version == "v1" {
+			if err := os.Chtimes(p, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	c, legacy, err := legacyConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legacy != filepath.Join("..", ".versions", "v2", "ims_config.json") {
+		t.Errorf("migrated %s, want the newest", legacy)
+	}
+	if len(c.InstanceManagers) != 2 || c.InstanceManagers[1].Name != "Ju Server" || c.InstanceManagers[1].Domain != "172.30.0.2:8000" {
+		t.Errorf("instance managers = %+v", c.InstanceManagers)
+	}
+	if c.Listen != ":8080" {
+		t.Errorf("listen = %q, want the default", c.Listen)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// knownIM reports whether domain belongs to a registered instance manager, so
+// the console endpoints can't be used to reach arbitrary hosts.
+func knownIM(domain string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, im := range instanceManagers {
+		if im.Domain == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// consoleProxyHandler relays the console stream of instance ?name= from the
+// IM at ?domain= to the dashboard.
+func consoleProxyHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	domain, name := q.Get("domain"), q.Get("name")
+	if domain == "" || name == "" {
+		http.Error(w, "domain and name are required", http.StatusBadRequest)
+		return
+	}
+	if !knownIM(domain) {
+		http.Error(w, fmt.Sprintf("unknown instance manager %q", domain), http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	target := url.URL{Scheme: "http", Host: domain, Path: "/console"}
+	query := url.Values{"name": {name}}
+	if lines := q.Get("lines"); lines != "" {
+		query.Set("lines", lines)
+	}
+	target.RawQuery = query.Encode()
+
+	// no client timeout: the stream lasts as long as the dashboard keeps it
+	// open, which cancels the request context
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, target.String(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to contact instance: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		http.Error(w, fmt.Sprintf("instance returned %s: %s", resp.Status, bytes.TrimSpace(body)), resp.StatusCode)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// commandProxyHandler forwards {"domain", "name", "command"} to the IM
+// running the instance.
+func commandProxyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Domain  string `json:"domain"`
+		Name    string `json:"name"`
+		Command string `json:"command"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Domain == "" || req.Name == "" || req.Command == "" {
+		http.Error(w, "domain, name and command are required", http.StatusBadRequest)
+		return
+	}
+	if !knownIM(req.Domain) {
+		http.Error(w, fmt.Sprintf("unknown instance manager %q", req.Domain), http.StatusNotFound)
+		return
+	}
+
+	target := url.URL{Scheme: "http", Host: req.Domain, Path: "/command", RawQuery: url.Values{"name": {req.Name}}.Encode()}
+	body, _ := json.Marshal(map[string]string{"command": req.Command})
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(target.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to contact instance: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		// pass on the IM's reason, e.g. a server that isn't running
+		http.Error(w, fmt.Sprintf("instance returned %s: %s", resp.Status, bytes.TrimSpace(respBody)), resp.StatusCode)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": string(respBody),
+	})
+}
+
+// logsProxyHandler forwards /logs and /logs/download of instance ?name= to the
+// IM at ?domain=, which keeps the logs after the instance has stopped.
+func logsProxyHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	domain, name := q.Get("domain"), q.Get("name")
+	if domain == "" || name == "" {
+		http.Error(w, "domain and name are required", http.StatusBadRequest)
+		return
+	}
+	if !knownIM(domain) {
+		http.Error(w, fmt.Sprintf("unknown instance manager %q", domain), http.StatusNotFound)
+		return
+	}
+
+	query := url.Values{"name": {name}}
+	if file := q.Get("file"); file != "" {
+		query.Set("file", file)
+	}
+	target := url.URL{Scheme: "http", Host: domain, Path: r.URL.Path, RawQuery: query.Encode()}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(target.String())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to contact instance: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range []string{"Content-Type", "Content-Length", "Content-Disposition", "Last-Modified"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// snapshotsProxyHandler forwards /snapshots and /snapshots/restore of instance
+// ?name= to the IM at ?domain=, which lists the world snapshots of the
+// instance or restores ?id= for its next start.
+func snapshotsProxyHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	domain, name := q.Get("domain"), q.Get("name")
+	if domain == "" || name == "" {
+		http.Error(w, "domain and name are required", http.StatusBadRequest)
+		return
+	}
+	if !knownIM(domain) {
+		http.Error(w, fmt.Sprintf("unknown instance manager %q", domain), http.StatusNotFound)
+		return
+	}
+
+	query := url.Values{"name": {name}}
+	if id := q.Get("id"); id != "" {
+		query.Set("id", id)
+	}
+	target := url.URL{Scheme: "http", Host: domain, Path: r.URL.Path, RawQuery: query.Encode()}
+
+	// restoring downloads and uploads a whole world
+	client := &http.Client{Timeout: 10 * time.Minute}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to contact instance: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if v := resp.Header.Get("Content-Type"); v != "" {
+		w.Header().Set("Content-Type", v)
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -11,9 +12,8 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
-	"regexp"
+	"path"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -35,6 +35,22 @@ type Instance struct {
 	TPS         int8     `json:"tps"`
 	Port        int      `json:"port"`
 	Status      string   `json:"status"`
+	Template    string   `json:"template,omitempty"`
+	SaveOnStop  bool     `json:"save_on_stop,omitempty"`
+	Persistent  bool     `json:"persistent,omitempty"`
+	// set by the IM once the server has crashed
+	ExitCode *int     `json:"exit_code,omitempty"`
+	Restarts int      `json:"restarts,omitempty"`
+	LastLog  []string `json:"last_log,omitempty"`
+}
+
+// Template is a server type as reported by an IM: instances whose name
+// matches Pattern are set up from it.
+type Template struct {
+	Name       string `json:"name"`
+	Pattern    string `json:"pattern"`
+	SaveOnStop bool   `json:"save_on_stop,omitempty"`
+	Persistent bool   `json:"persistent,omitempty"`
 }
 
 type InstanceManager struct {
@@ -45,6 +61,7 @@ type InstanceManager struct {
 	RAMUsedMB  uint64     `json:"ram_used_mb,omitempty"`
 	RAMTotalMB uint64     `json:"ram_total_mb,omitempty"`
 	Instances  []Instance `json:"instances,omitempty"`
+	Templates  []Template `json:"templates,omitempty"`
 }
 
 type Proxy struct {
@@ -60,6 +77,7 @@ type SystemInfo struct {
 	RAMUsedMB  uint64     `json:"ram_used_mb,omitempty"`
 	RAMTotalMB uint64     `json:"ram_total_mb,omitempty"`
 	Instances  []Instance `json:"instances,omitempty"`
+	Templates  []Template `json:"templates,omitempty"`
 }
 
 type ConfigIM struct {
@@ -89,65 +107,47 @@ type GlobalSummary struct {
 var (
 	players          []Player
 	instanceManagers []InstanceManager
-	configFile       = "ims_config.json"
 	mu               sync.Mutex
 	httpClient       = &http.Client{Timeout: 5 * time.Second}
 	velocityCtx      context.Context
 	velocityCancel   context.CancelFunc
 )
 
-// Load instance managers from config
-func loadConfig() {
-	file, err := os.ReadFile(configFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			instanceManagers = []InstanceManager{}
-			return
-		}
-		log.Fatalf("Failed to read config: %v", err)
-	}
-
-	var cfg []ConfigIM
-	if err := json.Unmarshal(file, &cfg); err != nil {
-		log.Fatalf("Failed to parse config: %v", err)
-	}
-
-	for _, c := range cfg {
-		instanceManagers = append(instanceManagers, InstanceManager{
-			Domain: c.Domain,
-			Name:   c.Name,
-		})
-	}
-}
-
-// Save instance managers to config (only Domain + Name)
+// saveConfig writes the instance manager list back into the config file,
+// keeping the rest of the active config.
 func saveConfig() {
 	mu.Lock()
-	defer mu.Unlock()
-	var cfg []ConfigIM
+	ims := make([]ConfigIM, 0, len(instanceManagers))
 	for _, im := range instanceManagers {
-		cfg = append(cfg, ConfigIM{
+		ims = append(ims, ConfigIM{
 			Domain: im.Domain,
 			Name:   im.Name,
 		})
 	}
+	mu.Unlock()
 
-	data, err := json.MarshalIndent(cfg, "", "  ")
-	if err != nil {
-		log.Printf("Failed to marshal config: %v", err)
+	c := *currentConfig()
+	c.InstanceManagers = ims
+	if err := c.Validate(); err != nil {
+		log.Printf("Not saving invalid config: %v", err)
 		return
 	}
-
-	if err := os.WriteFile(configFile, data, 0644); err != nil {
+	modTime, err := writeConfigFile(&c)
+	if err != nil {
 		log.Printf("Failed to write config file: %v", err)
+		return
 	}
+	cfgMu.Lock()
+	cfg = &c
+	configModTime = modTime
+	cfgMu.Unlock()
 }
 
 // Endpoint to get instance summary
 func fetchLocalProxyStatus() ProxyStatus {
 	var proxyResp ProxyStatus
 	client := &http.Client{Timeout: 2 * time.Second}
-	resp, err := client.Get("http://localhost:8081/status")
+	resp, err := client.Get(currentConfig().proxyURL("/status"))
 	if err != nil {
 		proxyResp.Error = err.Error()
 	} else {
@@ -222,6 +222,7 @@ func fetchInstanceSummaries() []InstanceManager {
 			im.RAMUsedMB = sys.RAMUsedMB
 			im.RAMTotalMB = sys.RAMTotalMB
 			im.Instances = sys.Instances
+			im.Templates = sys.Templates
 			im.State = "Online"
 			ch <- im
 		}(im)
@@ -372,6 +373,51 @@ func deleteIM(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Instance manager '%s' deleted", req.Name)
 }
 
+// instanceEventHandler receives the state changes an IM makes on its own: a
+// crashed server is removed from the proxy, a restarted one registered again.
+func instanceEventHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var ev struct {
+		Name     string `json:"name"`
+		Status   string `json:"status"`
+		ExitCode *int   `json:"exit_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if ev.Name == "" {
+		http.Error(w, "Missing name", http.StatusBadRequest)
+		return
+	}
+
+	switch ev.Status {
+	case "crashed":
+		if ev.ExitCode != nil {
+			log.Printf("Instance '%s' crashed with exit code %d", ev.Name, *ev.ExitCode)
+		} else {
+			log.Printf("Instance '%s' crashed", ev.Name)
+		}
+		go func() {
+			if err := removeServerFromProxy(ev.Name); err != nil {
+				log.Printf("Failed to remove crashed instance '%s' from proxy: %v", ev.Name, err)
+			}
+		}()
+	case "running":
+		log.Printf("Instance '%s' was restarted", ev.Name)
+		go ensureInstance(ev.Name)
+	default:
+		http.Error(w, fmt.Sprintf("Unknown status %q", ev.Status), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
 // getCPUPercent uses gopsutil to sample CPU usage percentage.
 func getCPUPercent() (float64, error) {
 	// cpu.Percent takes an interval and whether to get per-cpu. Setting interval > 0 blocks for the interval.
@@ -416,9 +462,10 @@ func hasStringInSlice(s []interface{}, name string) bool {
 
 // Try multiple proxy endpoints/formats and return true if lobby exists
 func proxyHasInstance(name string) (bool, error) {
+	c := currentConfig()
 	endpoints := []string{
-		"http://localhost:8081/status",
-		"http://localhost:8081/list_servers",
+		c.proxyURL("/status"),
+		c.proxyURL("/list_servers"),
 	}
 
 	for _, ep := range endpoints {
@@ -467,7 +514,7 @@ func proxyHasInstance(name string) (bool, error) {
 
 func getInstanceSummary() ([]InstanceManager, error) {
 	// Updated to call the new /status endpoint
-	resp, err := httpClient.Get("http://localhost:8080/status")
+	resp, err := httpClient.Get(currentConfig().selfURL("/status"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to call /status: %v", err)
 	}
@@ -500,7 +547,8 @@ func registerInstanceToProxy(name, domain string, port int) {
 	}
 
 	addURL := fmt.Sprintf(
-		"http://localhost:8081/add_server?name=%s&host=%s&port=%d",
+		"%s?name=%s&host=%s&port=%d",
+		currentConfig().proxyURL("/add_server"),
 		url.QueryEscape(name),
 		url.QueryEscape(host),
 		port,
@@ -524,9 +572,11 @@ func registerInstanceToProxy(name, domain string, port int) {
 	log.Printf("Instance '%s' registered to proxy (host: %s, port: %d).", name, host, port)
 }
 
-func saveWorldOnIM(domain, name string) error {
-	stopURL := fmt.Sprintf("http://%s/save-instance?name=%s", domain, url.QueryEscape(name))
-	client := &http.Client{Timeout: 30 * time.Second}
+// saveWorldOnIM saves the world of name as a snapshot recording trigger.
+func saveWorldOnIM(domain, name, trigger string) error {
+	stopURL := fmt.Sprintf("http://%s/save-instance?name=%s&trigger=%s", domain, url.QueryEscape(name), url.QueryEscape(trigger))
+	// the IM stops the server, uploads the world and starts it again
+	client := &http.Client{Timeout: time.Duration(currentConfig().StopTimeout)}
 	resp, err := client.Get(stopURL)
 	if err != nil {
 		return fmt.Errorf("request to IM %s failed: %w", stopURL, err)
@@ -541,7 +591,8 @@ func saveWorldOnIM(domain, name string) error {
 
 func stopServerOnIM(domain, name string) error {
 	stopURL := fmt.Sprintf("http://%s/stop-server?name=%s", domain, url.QueryEscape(name))
-	client := &http.Client{Timeout: 30 * time.Second}
+	// the IM answers once the server saved and exited, or was killed
+	client := &http.Client{Timeout: time.Duration(currentConfig().StopTimeout)}
 	resp, err := client.Get(stopURL)
 	if err != nil {
 		return fmt.Errorf("request to IM %s failed: %w", stopURL, err)
@@ -551,14 +602,19 @@ func stopServerOnIM(domain, name string) error {
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("IM stop-server returned status %d: %s", resp.StatusCode, string(body))
 	}
+	var result struct {
+		Outcome string `json:"outcome"`
+		Saved   bool   `json:"saved"`
+	}
+	if err := json.Unmarshal(body, &result); err == nil && result.Outcome == "killed" {
+		log.Printf("Instance '%s' on %s had to be killed (world saved: %t)", name, domain, result.Saved)
+	}
 	return nil
 }
 
 // removeServerFromProxy requests the proxy to remove the server from its registration.
-// NOTE: adjust proxyAdminAddr to the actual proxy admin API host:port.
 func removeServerFromProxy(name string) error {
-	proxyAdminAddr := "http://localhost:8081"
-	removeURL := fmt.Sprintf("%s/remove_server?name=%s", proxyAdminAddr, url.QueryEscape(name))
+	removeURL := fmt.Sprintf("%s?name=%s", currentConfig().proxyURL("/remove_server"), url.QueryEscape(name))
 
 	client := &http.Client{Timeout: 15 * time.Second}
 	resp, err := client.Get(removeURL)
@@ -574,7 +630,7 @@ func removeServerFromProxy(name string) error {
 }
 
 // cleanupEmptyServers scans all IMs and stops/unregisters servers with PlayerCount == 0.
-// It explicitly skips any server named "lobby".
+// It explicitly skips the lobby server and servers whose template is persistent.
 func cleanupEmptyServers() {
 	ims, err := getInstanceSummary()
 	fmt.Println(ims)
@@ -587,19 +643,20 @@ func cleanupEmptyServers() {
 		return
 	}
 
+	lobby := currentConfig().LobbyServer
 	for _, im := range ims {
 		for _, inst := range im.Instances {
-			// skip lobby by name
-			if inst.Name == "lobby" {
+			// skip lobby by name and servers that are kept running
+			if inst.Name == lobby || inst.Persistent {
 				continue
 			}
 			// only consider servers that are running/started (you can extend statuses if desired)
 			if inst.PlayerCount == 0 && (inst.Status == "running" || inst.Status == "started") {
 				log.Printf("cleanup: found empty instance '%s' on %s (port %d). Attempting to stop and unregister.", inst.Name, im.Domain, inst.Port)
 
-				// Save world before stopping (optional, depending on your needs)
-				if strings.HasPrefix(inst.Name, "lunaris_asteroid_") {
-					if err := saveWorldOnIM(im.Domain, inst.Name); err != nil {
+				// Save world before stopping if the server's template asks for it
+				if inst.SaveOnStop {
+					if err := saveWorldOnIM(im.Domain, inst.Name, "cleanup"); err != nil {
 						log.Printf("cleanup: failed to save world for instance '%s' on %s: %v", inst.Name, im.Domain, err)
 						// continue to next instance — don't attempt stop or remove if save failed
 						continue
@@ -631,12 +688,23 @@ func cleanupEmptyServers() {
 	}
 }
 
+// transitional reports whether an IM server state changes on its own, ending
+// in "running" or "crashed".
+func transitional(status string) bool {
+	switch status {
+	case "creating", "starting", "saving", "restarting":
+		return true
+	}
+	return false
+}
+
 // waitForInstance polls the instance summary until an instance is "running".
 func waitForInstance(name string) {
-	log.Printf("Waiting for instance '%s' to finish 'restarting'...", name)
+	log.Printf("Waiting for instance '%s' to finish starting...", name)
 
-	// Poll for 60 seconds (12 retries * 5 seconds)
-	for i := 0; i < 12; i++ {
+	// Poll every 5 seconds until the start timeout
+	deadline := time.Now().Add(time.Duration(currentConfig().StartTimeout))
+	for time.Now().Before(deadline) {
 		time.Sleep(5 * time.Second)
 
 		ims, err := getInstanceSummary()
@@ -650,13 +718,13 @@ func waitForInstance(name string) {
 			for _, inst := range im.Instances {
 				if inst.Name == name {
 					found = true
-					switch inst.Status {
-					case "running":
+					switch {
+					case inst.Status == "running":
 						log.Printf("Instance '%s' is now 'running'. Registering.", name)
 						registerInstanceToProxy(name, im.Domain, inst.Port)
 						return // Success
-					case "restarting":
-						log.Printf("... instance '%s' is still 'restarting'.", name)
+					case transitional(inst.Status):
+						log.Printf("... instance '%s' is still '%s'.", name, inst.Status)
 					default:
 						log.Printf("Instance '%s' changed to unexpected status '%s' while waiting. Aborting.", name, inst.Status)
 						return // Error
@@ -678,11 +746,42 @@ func waitForInstance(name string) {
 	log.Printf("Timed out waiting for instance '%s' to restart.", name)
 }
 
-var PreferredIMs = map[string][]string{
-	"lobby":            {"Ju Server"},
-	"lunaris_asteroid": {"Ju PC"},
-	"lunaris":          {"Ju PC"},
-	"wheat":            {"Ju PC"},
+// serverType returns the template the IMs set up the server name from, or
+// name itself if no online IM reports a matching template. The templates of
+// different IMs have no order among each other, so the most specific pattern
+// wins: an exact name over any wildcard, otherwise the longest pattern, and a
+// catch-all "*" only counts if nothing else matches.
+func serverType(name string, ims []InstanceManager) string {
+	best, bestPattern := name, ""
+	for _, im := range ims {
+		if im.State != "Online" {
+			continue
+		}
+		for _, t := range im.Templates {
+			if ok, _ := path.Match(t.Pattern, name); ok && (bestPattern == "" || moreSpecific(t.Pattern, bestPattern)) {
+				best, bestPattern = t.Name, t.Pattern
+			}
+		}
+	}
+	return best
+}
+
+// moreSpecific reports whether the pattern a is more specific than b.
+func moreSpecific(a, b string) bool {
+	aWild, bWild := strings.ContainsAny(a, "*?["), strings.ContainsAny(b, "*?[")
+	if aWild != bWild {
+		return !aWild
+	}
+	return len(a) > len(b)
+}
+
+// preferredIMsKey returns the key of preferred_ims that applies to the
+// server name: its own name if listed, otherwise its template's.
+func preferredIMsKey(name string, ims []InstanceManager) string {
+	if _, ok := currentConfig().PreferredIMs[name]; ok {
+		return name
+	}
+	return serverType(name, ims)
 }
 
 func pickInstanceManagerForServer(serverType string, ims []InstanceManager) *InstanceManager {
@@ -700,7 +799,7 @@ func pickInstanceManagerForServer(serverType string, ims []InstanceManager) *Ins
 	}
 
 	// 2) Check for preferred IM list
-	preferred, hasPreferred := PreferredIMs[serverType]
+	preferred, hasPreferred := currentConfig().PreferredIMs[serverType]
 	var filtered []InstanceManager
 
 	if hasPreferred {
@@ -760,6 +859,7 @@ func ensureInstance(name string) {
 	}
 
 	// 3) Check if the instance is already running anywhere
+	var crashedOn *InstanceManager
 	for _, im := range ims {
 		for _, inst := range im.Instances {
 			if inst.Name == name {
@@ -781,12 +881,21 @@ func ensureInstance(name string) {
 					}
 					registerInstanceToProxy(name, im.Domain, inst.Port)
 					return // Success
-				case "restarting":
-					log.Printf("Found 'restarting' instance '%s' on %s.", name, im.Domain)
+				case "creating", "starting":
+					// the IM hands out the job of the start in progress
+					log.Printf("Found '%s' instance '%s' on %s. Following its start.", inst.Status, name, im.Domain)
+					startAndRegister(&im, name)
+					return
+				case "saving", "restarting":
+					log.Printf("Found '%s' instance '%s' on %s.", inst.Status, name, im.Domain)
 					waitForInstance(name) // This function will wait, then register or time out
 					return
+				case "crashed":
+					// start it again on the same IM, which replaces the crashed entry
+					log.Printf("Found crashed instance '%s' on %s. Starting it again there.", name, im.Domain)
+					crashedOn = &im
 				default:
-					// Any other status: "saving", "stopped", "creating", etc.
+					// Any other status: "stopping", etc.
 					log.Printf("Error: Instance '%s' found on %s but has an unhandled status: '%s'. Won't start a new one.", name, im.Domain, inst.Status)
 					return // Return with error
 				}
@@ -796,7 +905,10 @@ func ensureInstance(name string) {
 	}
 
 	// 4) No existing instance found: pick least-loaded IM
-	selected := pickInstanceManagerForServer(name, ims)
+	selected := crashedOn
+	if selected == nil {
+		selected = pickInstanceManagerForServer(preferredIMsKey(name, ims), ims)
+	}
 	if selected == nil {
 		return
 	}
@@ -804,70 +916,90 @@ func ensureInstance(name string) {
 	log.Printf("Selected IM %s (%s) with CPU %.2f%% RAM used %dMB",
 		selected.Name, selected.Domain, selected.CPUPercent, selected.RAMUsedMB)
 
-	// 5) Start the instance via /start-server
-	startURL := fmt.Sprintf("http://%s/start-server?name=%s", selected.Domain, url.QueryEscape(name))
-	// Longer timeout for starting a server
-	client := &http.Client{Timeout: 90 * time.Second} // Increased timeout
-	resp3, err := client.Get(startURL)
+	// 5) Start the instance and register it with the proxy
+	startAndRegister(selected, name)
+}
+
+// startAndRegister starts the instance name on im and registers it with the
+// proxy once it is running.
+func startAndRegister(im *InstanceManager, name string) {
+	port, err := startServerOnIM(im.Domain, name)
 	if err != nil {
-		log.Printf("Failed to call %s: %v", startURL, err)
+		log.Printf("Failed to start instance '%s' on %s: %v", name, im.Domain, err)
 		return
 	}
-	body3, err := io.ReadAll(resp3.Body)
-	resp3.Body.Close()
+	log.Printf("Started instance '%s' on %s:%d", name, im.Domain, port)
+
+	registerInstanceToProxy(name, im.Domain, port)
+
+	log.Printf("Proxy /add_server success for new instance '%s'.", name)
+}
+
+// startJob is the progress of a server start as reported by an IM's
+// /jobs/{id}.
+type startJob struct {
+	Phase    string  `json:"phase"`
+	Progress float64 `json:"progress"`
+	Port     int     `json:"port"`
+	Error    string  `json:"error"`
+}
+
+// startServerOnIM asks the IM at domain to start the server name and follows
+// the start job until the server is running, returning its port.
+func startServerOnIM(domain, name string) (int, error) {
+	startURL := fmt.Sprintf("http://%s/start-server?name=%s", domain, url.QueryEscape(name))
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Get(startURL)
 	if err != nil {
-		log.Printf("Failed to read start-server response: %v", err)
-		return
+		return 0, fmt.Errorf("request to %s failed: %w", startURL, err)
 	}
-
-	if resp3.StatusCode != http.StatusOK {
-		log.Printf("start-server failed with status %d: %s", resp3.StatusCode, string(body3))
-		return
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return 0, fmt.Errorf("start-server returned status %d: %s", resp.StatusCode, string(body))
 	}
-
-	// 6) Parse port from response
-	port, parseErr := parsePortFromResponse(body3)
-	if parseErr != nil {
-		log.Printf("Failed to parse port from start-server response: %v -- body: %s", parseErr, string(body3))
-		return
+	var started struct {
+		Job string `json:"job"`
+	}
+	if err := json.Unmarshal(body, &started); err != nil || started.Job == "" {
+		return 0, fmt.Errorf("no job in start-server response: %s", string(body))
 	}
-	log.Printf("Started instance '%s' on %s:%d", name, selected.Domain, port)
-
-	// 7) Register the new instance with the proxy
-	registerInstanceToProxy(name, selected.Domain, port)
 
-	log.Printf("Proxy /add_server success for new instance '%s'.", name)
-}
+	jobURL := fmt.Sprintf("http://%s/jobs/%s", domain, url.PathEscape(started.Job))
+	deadline := time.Now().Add(time.Duration(currentConfig().StartTimeout))
+	lastPhase := ""
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
 
-// parsePortFromResponse tries to decode JSON {"port":N} or extract first integer in the body as port.
-func parsePortFromResponse(body []byte) (int, error) {
-	// try JSON
-	var j map[string]interface{}
-	if err := json.Unmarshal(body, &j); err == nil {
-		if p, ok := j["port"]; ok {
-			switch v := p.(type) {
-			case float64:
-				return int(v), nil
-			case int:
-				return v, nil
-			case string:
-				if pi, err := strconv.Atoi(v); err == nil {
-					return pi, nil
-				}
-			}
+		resp, err := client.Get(jobURL)
+		if err != nil {
+			log.Printf("Error polling start job of '%s': %v", name, err)
+			continue // Try again
+		}
+		var job startJob
+		err = json.NewDecoder(resp.Body).Decode(&job)
+		status := resp.StatusCode
+		resp.Body.Close()
+		if status == http.StatusNotFound {
+			return 0, fmt.Errorf("start job %s disappeared from %s", started.Job, domain)
+		}
+		if status != http.StatusOK || err != nil {
+			log.Printf("Error polling start job of '%s': status %d, %v", name, status, err)
+			continue
 		}
-	}
 
-	// try to find digits in the plain text response
-	re := regexp.MustCompile(`\b([0-9]{2,6})\b`)
-	m := re.FindSubmatch(body)
-	if len(m) > 1 {
-		if p, err := strconv.Atoi(string(m[1])); err == nil {
-			return p, nil
+		switch job.Phase {
+		case "done":
+			return job.Port, nil
+		case "failed":
+			return 0, fmt.Errorf("start failed: %s", job.Error)
+		}
+		if job.Phase != lastPhase {
+			log.Printf("... instance '%s' is %s", name, job.Phase)
+			lastPhase = job.Phase
 		}
 	}
-
-	return 0, fmt.Errorf("no port found in response")
+	return 0, fmt.Errorf("start did not finish within %s", time.Duration(currentConfig().StartTimeout))
 }
 
 func moveHandler(w http.ResponseWriter, r *http.Request) {
@@ -912,7 +1044,7 @@ func moveHandler(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Ensure Instance done")
 
 	// Forward to local move_to endpoint.
-	endpoint := "http://localhost:8081/move_to"
+	endpoint := currentConfig().proxyURL("/move_to")
 	params := url.Values{}
 	params.Set("player", req.Name)
 	params.Set("server", req.Server)
@@ -976,7 +1108,7 @@ func moveAllHandler(w http.ResponseWriter, r *http.Request) {
 	ensureInstance(req.Origin)
 
 	// Forward to local move_to endpoint.
-	endpoint := "http://localhost:8081/move_from_to"
+	endpoint := currentConfig().proxyURL("/move_from_to")
 	params := url.Values{}
 	params.Set("origin", req.Origin)
 	params.Set("destination", req.Destination)
@@ -1034,6 +1166,12 @@ func runVelocity(dir, command string, args ...string) *exec.Cmd {
 	return cmd
 }
 
+// startVelocity (re)starts the proxy with the configured command line.
+func startVelocity() {
+	v := currentConfig().Velocity
+	runVelocity(v.Dir, v.Command[0], v.Command[1:]...)
+}
+
 func stopVelocity() {
 	if velocityCancel != nil {
 		velocityCancel()
@@ -1089,6 +1227,9 @@ func InstanceActionHandler(w http.ResponseWriter, r *http.Request) {
 	if req.Action != "pluginUpdate" {
 		query.Set("name", req.Name)
 	}
+	if req.Action == "save" {
+		query.Set("trigger", "manual")
+	}
 	targetURL.RawQuery = query.Encode()
 
 	fmt.Println("Sending request to:", targetURL.String())
@@ -1163,66 +1304,88 @@ func RestartHandler(w http.ResponseWriter, r *http.Request) {
 	// Stop the running command
 	stopVelocity()
 
-	// Start it again
-	runVelocity("./proxy", "java", "-jar", "velocity.jar")
+	// Start it again, picking up a changed command line from the config
+	startVelocity()
 
 	w.Write([]byte("Process restarted\n"))
 }
 
+// runPeriodically calls fn after delay and then every interval. Both are read
+// from the current config, so a reload applies from the next round on.
+func runPeriodically(delay, interval func(*Config) Duration, fn func()) {
+	time.Sleep(time.Duration(delay(currentConfig())))
+	for {
+		fn()
+		time.Sleep(time.Duration(interval(currentConfig())))
+	}
+}
+
 func main() {
-	loadConfig()
+	// the config is per node and lives outside the updated tree, as the
+	// instance managers are added to it; the bootstrapper exports its path
+	if p := os.Getenv("SM_CONFIG"); p != "" {
+		configPath = p
+	}
+	flag.StringVar(&configPath, "config", configPath, "path of the JSON config file (default: $SM_CONFIG)")
+	flag.Parse()
 
-	go func() {
-		// Step 1: npm install (blocking inside goroutine)
-		if err := runCommandWait("./website", "npm", "install"); err != nil {
-			log.Printf("npm install failed: %v", err)
-			return
-		}
+	loadConfig()
+	go watchConfig()
+	c := currentConfig()
+
+	if c.WebsiteDir != "" {
+		go func(dir string) {
+			// Step 1: npm install (blocking inside goroutine)
+			if err := runCommandWait(dir, "npm", "install"); err != nil {
+				log.Printf("npm install failed: %v", err)
+				return
+			}
 
-		// Step 2: npm run dev (also blocking inside goroutine)
-		// This process usually does not exit until you stop the program.
-		if err := runCommandWait("./website", "npm", "run", "dev"); err != nil {
-			log.Printf("npm run dev failed: %v", err)
-			return
-		}
-	}()
+			// Step 2: npm run dev (also blocking inside goroutine)
+			// This process usually does not exit until you stop the program.
+			if err := runCommandWait(dir, "npm", "run", "dev"); err != nil {
+				log.Printf("npm run dev failed: %v", err)
+				return
+			}
+		}(c.WebsiteDir)
+	}
 
-	runVelocity("./proxy", "java", "-jar", "velocity.jar")
+	startVelocity()
 
-	go func() {
-		time.Sleep(10 * time.Second) // let server come up
-		ticker := time.NewTicker(15 * time.Second)
-		defer ticker.Stop()
-		for {
-			ensureInstance("lobby")
-			<-ticker.C
-		}
-	}()
+	// keep the lobby running once the proxy had time to come up
+	go runPeriodically(
+		func(c *Config) Duration { return c.LobbyDelay },
+		func(c *Config) Duration { return c.LobbyInterval },
+		func() { ensureInstance(currentConfig().LobbyServer) },
+	)
 
-	go func() {
-		// wait a bit for system to become healthy
-		time.Sleep(7 * time.Second)
-		ticker := time.NewTicker(60 * time.Second)
-		defer ticker.Stop()
-		for {
-			cleanupEmptyServers()
-			<-ticker.C
-		}
-	}()
+	// stop empty servers, after giving the system a moment to become healthy
+	go runPeriodically(
+		func(c *Config) Duration { return c.CleanupDelay },
+		func(c *Config) Duration { return c.CleanupInterval },
+		cleanupEmptyServers,
+	)
 
 	http.HandleFunc("/player-add", addPlayer)
 	http.HandleFunc("/status", statusHandler)
+	http.HandleFunc("/config", configHandler)
 	http.HandleFunc("/create_im", createIM)
 	http.HandleFunc("/delete_im", deleteIM)
 	http.HandleFunc("/move", moveHandler)
 	http.HandleFunc("/move_all", moveAllHandler)
 	http.HandleFunc("/action", InstanceActionHandler)
+	http.HandleFunc("/instance-event", instanceEventHandler)
+	http.HandleFunc("/console", consoleProxyHandler)
+	http.HandleFunc("/command", commandProxyHandler)
+	http.HandleFunc("/logs", logsProxyHandler)
+	http.HandleFunc("/logs/download", logsProxyHandler)
+	http.HandleFunc("/snapshots", snapshotsProxyHandler)
+	http.HandleFunc("/snapshots/restore", snapshotsProxyHandler)
 	http.HandleFunc("/restart", RestartHandler)
 	//http.HandleFunc("/restart-instance", restartWorldHandler)
 
-	port := 8080
-	log.Printf("Server running on http://localhost:%d/\n", port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil); err != nil {
+	log.Printf("Server running on %s\n", c.selfURL("/"))
+	if err := http.ListenAndServe(c.Listen, nil); err != nil {
 		log.Fatalf("Server failed: %v", err)
 	}
 }
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestServerType(t *testing.T) {
+	ims := []InstanceManager{
+		// offline IMs keep the templates of their last answer
+		{Name: "stale", State: "Offline", Templates: []Template{{Name: "old", Pattern: "lunaris*"}}},
+		// the catch-all comes first, as the first template of the first IM
+		{Name: "plain", State: "Online", Templates: []Template{{Name: "default", Pattern: "*"}, {Name: "lobby", Pattern: "lobby"}}},
+		{Name: "asteroids", State: "Online", Templates: []Template{{Name: "lunaris_any", Pattern: "lunaris*"}, {Name: "lunaris_asteroid", Pattern: "lunaris_asteroid_*"}, {Name: "lunaris", Pattern: "lunaris"}}},
+	}
+	cases := map[string]string{
+		"lobby":                "lobby",
+		"lunaris_asteroid_bob": "lunaris_asteroid",
+		"lunaris":              "lunaris",
+		"lunaris_moon":         "lunaris_any",
+		"event":                "default",
+	}
+	for name, want := range cases {
+		if got := serverType(name, ims); got != want {
+			t.Errorf("serverType(%q) = %q, want %q", name, got, want)
+		}
+	}
+	if got := serverType("event", ims[2:]); got != "event" {
+		t.Errorf("serverType without a matching template = %q, want the name", got)
+	}
+
+	old := cfg
+	t.Cleanup(func() { cfg = old })
+	cfg = &Config{PreferredIMs: map[string][]string{"event": {"plain"}, "default": {"asteroids"}}}
+	for name, want := range map[string]string{"event": "event", "party": "default", "lobby": "lobby"} {
+		if got := preferredIMsKey(name, ims); got != want {
+			t.Errorf("preferredIMsKey(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package updater
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extractLimits bound what a single archive may unpack to, so that a
+// malicious or broken archive can't fill the disk.
+type extractLimits struct {
+	// uncompressed bytes and number of files and symlinks in total
+	MaxSize  int64
+	MaxFiles int
+	// symlinks are extracted if they stay inside the destination; without
+	// Symlinks every symlink is refused
+	Symlinks bool
+}
+
+// updateLimits apply to downloaded updates.
+var updateLimits = extractLimits{MaxSize: 2 << 30, MaxFiles: 100000, Symlinks: true}
+
+// extractArchive reads a zip or gzipped tar archive from r and extracts every
+// entry below subdir into dest. Archives that wrap everything in a single
+// top-level directory (GitHub zipballs, "git archive --prefix") are handled
+// too. An empty subdir extracts the whole archive.
+func extractArchive(r io.Reader, subdir, dest string) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+
+	x := &extractor{dest: dest, limits: updateLimits}
+	var err error
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		err = x.zip(br, subdir)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		err = x.tarGz(br, subdir)
+	default:
+		return errors.New("archive is neither a zip nor a gzipped tar")
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, statErr := os.Stat(dest); statErr != nil {
+		return fmt.Errorf("didn't find %s in repository archive", subdir)
+	}
+	return nil
+}
+
+// extractZip extracts every entry of zr below subdir into dest, matching
+// subdir like extractArchive does, and returns the number of extracted files
+// and symlinks. Entries that would leave dest or exceed limits fail with
+// ErrUnsafeArchive.
+func extractZip(zr *zip.Reader, subdir, dest string, limits extractLimits) (int, error) {
+	x := &extractor{dest: dest, limits: limits}
+	err := x.zipEntries(zr, subdir)
+	return x.files, err
+}
+
+// extractor writes archive entries below dest. It refuses entries that would
+// end up outside of dest and enforces its limits.
+type extractor struct {
+	dest    string
+	limits  extractLimits
+	files   int
+	written int64
+}
+
+func (x *extractor) zip(r io.Reader, subdir string) error {
+	// zip needs random access, so spool it to disk first
+	tmpZipFile, err := os.CreateTemp("", "repo-zip-*.zip")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmpZipFile.Close()
+		os.Remove(tmpZipFile.Name())
+	}()
+
+	size, err := io.Copy(tmpZipFile, r)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(tmpZipFile, size)
+	if err != nil {
+		return err
+	}
+	return x.zipEntries(zr, subdir)
+}
+
+func (x *extractor) zipEntries(zr *zip.Reader, subdir string) error {
+	var err error
+	for _, f := range zr.File {
+		rel, ok := subdirRel(f.Name, subdir)
+		if !ok {
+			continue
+		}
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.mkdir(rel)
+		case mode&os.ModeSymlink != 0:
+			err = x.zipSymlink(rel, f)
+		case mode.IsRegular():
+			var rc io.ReadCloser
+			if rc, err = f.Open(); err == nil {
+				err = x.writeFile(rel, mode, rc)
+				rc.Close()
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) zipSymlink(rel string, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	target, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err != nil {
+		return err
+	}
+	return x.symlink(rel, string(target))
+}
+
+func (x *extractor) tarGz(r io.Reader, subdir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, ok := subdirRel(hdr.Name, subdir)
+		if !ok {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.mkdir(rel)
+		case tar.TypeReg:
+			err = x.writeFile(rel, hdr.FileInfo().Mode(), tr)
+		case tar.TypeSymlink:
+			err = x.symlink(rel, hdr.Linkname)
+		case tar.TypeLink:
+			err = fmt.Errorf("%w: hard link %s", ErrUnsafeArchive, hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// path returns the location of the archive entry rel below dest. It rejects
+// absolute names, ".." segments and names that lead through a symlink.
+func (x *extractor) path(rel string) (string, error) {
+	if rel == "" {
+		return x.dest, nil
+	}
+	if strings.Contains(rel, `\`) || filepath.IsAbs(rel) || filepath.VolumeName(rel) != "" {
+		return "", fmt.Errorf("%w: %q is not a relative path", ErrUnsafeArchive, rel)
+	}
+	for _, seg := range strings.Split(rel, "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("%w: %q leaves the destination", ErrUnsafeArchive, rel)
+		}
+	}
+
+	// nothing below dest may be a symlink, or writing through it escapes
+	p := x.dest
+	for _, seg := range strings.Split(filepath.Clean(rel), string(filepath.Separator)) {
+		p = filepath.Join(p, seg)
+		if fi, err := os.Lstat(p); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w: %q goes through a symlink", ErrUnsafeArchive, rel)
+		}
+	}
+	return p, nil
+}
+
+// count enforces limits.MaxFiles.
+func (x *extractor) count() error {
+	x.files++
+	if x.files > x.limits.MaxFiles {
+		return fmt.Errorf("%w: more than %d entries", ErrUnsafeArchive, x.limits.MaxFiles)
+	}
+	return nil
+}
+
+func (x *extractor) mkdir(rel string) error {
+	p, err := x.path(rel)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(p, 0755)
+}
+
+func (x *extractor) writeFile(rel string, mode os.FileMode, r io.Reader) error {
+	if err := x.count(); err != nil {
+		return err
+	}
+	destPath, err := x.path(rel)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+	outf, err := os.OpenFile(destPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	// don't trust the sizes in the headers, count what is actually written
+	n, err := io.Copy(outf, io.LimitReader(r, x.limits.MaxSize-x.written+1))
+	outf.Close()
+	x.written += n
+	if err != nil {
+		return err
+	}
+	if x.written > x.limits.MaxSize {
+		return fmt.Errorf("%w: uncompressed size exceeds %d bytes", ErrUnsafeArchive, x.limits.MaxSize)
+	}
+	// owner always gets read/write, the execute bit is preserved
+	_ = os.Chmod(destPath, mode.Perm()|0600)
+	return nil
+}
+
+// symlink creates a relative symlink whose target stays inside dest.
+func (x *extractor) symlink(rel, target string) error {
+	if err := x.count(); err != nil {
+		return err
+	}
+	linkPath, err := x.path(rel)
+	if err != nil {
+		return err
+	}
+	if !x.limits.Symlinks {
+		return fmt.Errorf("%w: %q is a symlink", ErrUnsafeArchive, rel)
+	}
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("%w: symlink %q points to absolute path %q", ErrUnsafeArchive, rel, target)
+	}
+	resolved := filepath.Join(filepath.Dir(linkPath), target)
+	if r, err := filepath.Rel(x.dest, resolved); err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: symlink %q points outside the destination (%q)", ErrUnsafeArchive, rel, target)
+	}
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(target, linkPath)
+}
+
+// subdirRel returns the path of an archive entry relative to subdir, trying
+// the name as is and with its first path component stripped.
+func subdirRel(name, subdir string) (string, bool) {
+	name = strings.TrimPrefix(name, "./")
+	if subdir == "" {
+		return name, true
+	}
+	candidates := []string{name}
+	if i := strings.Index(name, "/"); i >= 0 {
+		candidates = append(candidates, name[i+1:])
+	}
+	for _, c := range candidates {
+		if strings.TrimSuffix(c, "/") == subdir {
+			return "", true
+		}
+		if strings.HasPrefix(c, subdir+"/") {
+			return strings.TrimPrefix(c, subdir+"/"), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,256 @@
+package updater
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type entry struct {
+	name string
+	body string
+	link string // symlink target
+	dir  bool
+}
+
+func makeZip(t *testing.T, entries []entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		body := e.body
+		switch {
+		case e.dir:
+			hdr.SetMode(os.ModeDir | 0755)
+		case e.link != "":
+			hdr.SetMode(os.ModeSymlink | 0777)
+			body = e.link
+		default:
+			hdr.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeTarGz(t *testing.T, entries []entry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		switch {
+		case e.dir:
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var archiveFormats = map[string]func(*testing.T, []entry) []byte{
+	"zip":    makeZip,
+	"tar.gz": makeTarGz,
+}
+
+func TestExtractArchiveSubdir(t *testing.T) {
+	for format, build := range archiveFormats {
+		t.Run(format, func(t *testing.T) {
+			data := build(t, []entry{
+				{name: "owner-repo-abc/", dir: true},
+				{name: "owner-repo-abc/README.md", body: "ignored"},
+				{name: "owner-repo-abc/im_main/instance_manager/", dir: true},
+				{name: "owner-repo-abc/im_main/instance_manager/main.go", body: "package main"},
+				{name: "owner-repo-abc/im_main/instance_manager/plugins/a.jar", body: "jar"},
+				{name: "owner-repo-abc/im_main/instance_manager/plugins/latest.jar", link: "a.jar"},
+			})
+			dest := filepath.Join(t.TempDir(), "out")
+			if err := extractArchive(bytes.NewReader(data), "im_main/instance_manager", dest); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range map[string]string{"main.go": "package main", "plugins/a.jar": "jar", "plugins/latest.jar": "jar"} {
+				got, err := os.ReadFile(filepath.Join(dest, name))
+				if err != nil || string(got) != want {
+					t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dest, "README.md")); err == nil {
+				t.Error("file outside of subdir was extracted")
+			}
+		})
+	}
+}
+
+func TestExtractArchiveRejectsMaliciousEntries(t *testing.T) {
+	cases := map[string][]entry{
+		"dot-dot":          {{name: "../evil", body: "x"}},
+		"nested dot-dot":   {{name: "a/../../evil", body: "x"}},
+		"absolute":         {{name: "/tmp/evil", body: "x"}},
+		"backslash":        {{name: `..\evil`, body: "x"}},
+		"absolute symlink": {{name: "link", link: "/etc"}},
+		"escaping symlink": {{name: "a/link", link: "../../etc"}},
+		"write through symlink": {
+			{name: "dir/", dir: true},
+			{name: "link", link: "dir"},
+			{name: "link/evil", body: "x"},
+		},
+	}
+
+	for format, build := range archiveFormats {
+		for name, entries := range cases {
+			t.Run(format+"/"+name, func(t *testing.T) {
+				root := t.TempDir()
+				dest := filepath.Join(root, "out")
+				err := extractArchive(bytes.NewReader(build(t, entries)), "", dest)
+				if !errors.Is(err, ErrUnsafeArchive) {
+					t.Fatalf("err = %v, want ErrUnsafeArchive", err)
+				}
+				if _, err := os.Stat(filepath.Join(root, "evil")); err == nil {
+					t.Error("entry was written outside of the destination")
+				}
+			})
+		}
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	defer func(limits extractLimits) { updateLimits = limits }(updateLimits)
+	updateLimits = extractLimits{MaxSize: 1024, MaxFiles: 3, Symlinks: true}
+
+	cases := map[string][]entry{
+		"too large": {{name: "big", body: strings.Repeat("0", 2048)}},
+		"too large in total": {
+			{name: "a", body: strings.Repeat("0", 600)},
+			{name: "b", body: strings.Repeat("0", 600)},
+		},
+		"too many files": {{name: "a"}, {name: "b"}, {name: "c"}, {name: "d"}},
+	}
+
+	for format, build := range archiveFormats {
+		for name, entries := range cases {
+			t.Run(format+"/"+name, func(t *testing.T) {
+				dest := filepath.Join(t.TempDir(), "out")
+				err := extractArchive(bytes.NewReader(build(t, entries)), "", dest)
+				if !errors.Is(err, ErrUnsafeArchive) {
+					t.Fatalf("err = %v, want ErrUnsafeArchive", err)
+				}
+			})
+		}
+	}
+}
+
+func zipReader(t *testing.T, entries []entry) *zip.Reader {
+	t.Helper()
+	data := makeZip(t, entries)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestExtractZipPrefix(t *testing.T) {
+	zr := zipReader(t, []entry{
+		{name: "owner-repo-abc/README.md", body: "ignored"},
+		{name: "owner-repo-abc/im_main/instance_manager/plugins/", dir: true},
+		{name: "owner-repo-abc/im_main/instance_manager/plugins/a.jar", body: "jar"},
+		{name: "owner-repo-abc/im_main/instance_manager/plugins/sub/b.yml", body: "yml"},
+	})
+	dest := filepath.Join(t.TempDir(), "plugins")
+
+	n, err := extractZip(zr, "owner-repo-abc/im_main/instance_manager/plugins", dest, updateLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("extracted %d files, want 2", n)
+	}
+	for name, want := range map[string]string{"a.jar": "jar", "sub/b.yml": "yml"} {
+		got, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestExtractZipWithoutSymlinks(t *testing.T) {
+	limits := extractLimits{MaxSize: 1024, MaxFiles: 10}
+	cases := map[string]struct {
+		subdir  string
+		entries []entry
+	}{
+		"symlink":          {"", []entry{{name: "a", body: "x"}, {name: "link", link: "a"}}},
+		"prefixed dot-dot": {"repo/plugins", []entry{{name: "repo/plugins/../../../evil", body: "x"}}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			dest := filepath.Join(root, "a", "b")
+			_, err := extractZip(zipReader(t, c.entries), c.subdir, dest, limits)
+			if !errors.Is(err, ErrUnsafeArchive) {
+				t.Fatalf("err = %v, want ErrUnsafeArchive", err)
+			}
+			if _, err := os.Lstat(filepath.Join(dest, "link")); err == nil {
+				t.Error("symlink was created")
+			}
+			if _, err := os.Stat(filepath.Join(root, "evil")); err == nil {
+				t.Error("entry was written outside of the destination")
+			}
+		})
+	}
+}
+
+func TestExtractZipRejectsExistingSymlink(t *testing.T) {
+	root := t.TempDir()
+	dest := filepath.Join(root, "world")
+	outside := filepath.Join(root, "outside")
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dest, "region")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := extractZip(zipReader(t, []entry{{name: "region/r.0.0.mca", body: "x"}}), "", dest, updateLimits)
+	if !errors.Is(err, ErrUnsafeArchive) {
+		t.Fatalf("err = %v, want ErrUnsafeArchive", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "r.0.0.mca")); err == nil {
+		t.Error("entry was written through the symlink")
+	}
+}
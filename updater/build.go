@@ -0,0 +1,151 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// lastGoodFile lives in BinDir and names the most recent binary that built
+// successfully.
+const lastGoodFile = "last_good"
+
+// command returns the executable and arguments used to start the managed
+// process. Without an explicit RunCommand the local tree is compiled once per
+// version and the cached binary is run.
+func (u *Updater) command() ([]string, error) {
+	if len(u.cfg.RunCommand) > 0 {
+		return u.cfg.RunCommand, nil
+	}
+	bin, err := u.prepareBinary()
+	if err != nil {
+		return nil, err
+	}
+	return []string{bin}, nil
+}
+
+// prepareBinary returns the binary for the local version, building it if it
+// is not cached yet. If the build fails the previous good binary is used.
+func (u *Updater) prepareBinary() (string, error) {
+	version, _ := u.readLocalVersion()
+	bin, err := filepath.Abs(u.binaryPath(version))
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(bin); err == nil {
+		return bin, nil
+	}
+
+	buildErr := u.failedBuilds[bin]
+	if buildErr == nil {
+		buildErr = u.build(bin)
+	}
+	if buildErr != nil {
+		// don't recompile a known-broken tree on every restart
+		u.failedBuilds[bin] = buildErr
+		prev, err := u.lastGoodBinary()
+		if err != nil {
+			return "", fmt.Errorf("build failed and no previous binary is available: %w", buildErr)
+		}
+		log.Printf("Build of %s failed: %v. Falling back to previous binary %s", u.cfg.LocalDir, buildErr, prev)
+		return prev, nil
+	}
+
+	prev, _ := u.lastGoodBinary()
+	if err := os.WriteFile(filepath.Join(u.cfg.BinDir, lastGoodFile), []byte(bin), 0644); err != nil {
+		log.Printf("Warning: failed to record last good binary: %v", err)
+	}
+	u.pruneBinaries(bin, prev)
+	return bin, nil
+}
+
+// build compiles LocalDir into bin.
+func (u *Updater) build(bin string) error {
+	if err := os.MkdirAll(filepath.Dir(bin), 0755); err != nil {
+		return err
+	}
+	tmp := bin + ".tmp"
+	defer os.Remove(tmp)
+
+	cmd := exec.Command("go", "build", "-o", tmp, ".")
+	cmd.Dir = u.cfg.LocalDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+
+	log.Printf("Building ./%s into %s ...", u.cfg.LocalDir, bin)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go build failed: %w", err)
+	}
+	return os.Rename(tmp, bin)
+}
+
+func (u *Updater) binaryPath(version string) string {
+	if version == "" {
+		version = "unversioned"
+	}
+	name := filepath.Base(u.cfg.LocalDir) + "-" + sanitizeVersion(version)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(u.cfg.BinDir, name)
+}
+
+func (u *Updater) lastGoodBinary() (string, error) {
+	b, err := os.ReadFile(filepath.Join(u.cfg.BinDir, lastGoodFile))
+	if err != nil {
+		return "", err
+	}
+	bin := strings.TrimSpace(string(b))
+	if bin == "" {
+		return "", errors.New("no previous binary recorded")
+	}
+	if _, err := os.Stat(bin); err != nil {
+		return "", err
+	}
+	return bin, nil
+}
+
+// pruneBinaries removes every cached binary except the ones in keep.
+func (u *Updater) pruneBinaries(keep ...string) {
+	entries, err := os.ReadDir(u.cfg.BinDir)
+	if err != nil {
+		return
+	}
+	prefix := filepath.Base(u.cfg.LocalDir) + "-"
+outer:
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		p, err := filepath.Abs(filepath.Join(u.cfg.BinDir, e.Name()))
+		if err != nil {
+			continue
+		}
+		for _, k := range keep {
+			if p == k {
+				continue outer
+			}
+		}
+		if err := os.Remove(p); err == nil {
+			log.Printf("Removed old binary %s", p)
+		}
+	}
+}
+
+// sanitizeVersion makes a version usable as part of a file name
+// (timestamps contain ':' for example).
+func sanitizeVersion(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_':
+			return r
+		}
+		return '-'
+	}, v)
+}
@@ -0,0 +1,73 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrepareBinaryFallsBackToLastGood(t *testing.T) {
+	root := t.TempDir()
+	cfg := testConfig(root)
+	cfg.RunCommand = nil
+	cfg.BinDir = filepath.Join(root, ".bin")
+	u := New(cfg)
+
+	installLocal(t, cfg, "v1", map[string]string{
+		"go.mod":  "module probe\n\ngo 1.21\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+	good, err := u.prepareBinary()
+	if err != nil {
+		t.Fatalf("building v1: %v", err)
+	}
+	if want, _ := filepath.Abs(u.binaryPath("v1")); good != want {
+		t.Errorf("binary = %s, want %s", good, want)
+	}
+	if last, err := u.lastGoodBinary(); err != nil || last != good {
+		t.Errorf("last good = %q, %v; want %s", last, err, good)
+	}
+
+	// v2 doesn't compile, so v1's binary keeps running
+	installLocal(t, cfg, "v2", map[string]string{"main.go": "package main\n\nfunc main() { broken }\n"})
+	bin, err := u.prepareBinary()
+	if err != nil || bin != good {
+		t.Fatalf("prepareBinary = %q, %v; want fallback to %s", bin, err, good)
+	}
+	broken, _ := filepath.Abs(u.binaryPath("v2"))
+	if u.failedBuilds[broken] == nil {
+		t.Error("failed build of v2 wasn't remembered")
+	}
+	if _, err := os.Stat(broken); !os.IsNotExist(err) {
+		t.Errorf("binary of broken v2 exists: %v", err)
+	}
+
+	// the remembered failure isn't rebuilt, and the fallback is started
+	c, err := u.startChild()
+	if err != nil {
+		t.Fatalf("startChild = %v, want v1's binary started", err)
+	}
+	if c.cmd.Path != good {
+		t.Errorf("started %s, want %s", c.cmd.Path, good)
+	}
+	if err := <-c.done; err != nil {
+		t.Errorf("v1's binary exited with %v", err)
+	}
+}
+
+func TestPrepareBinaryWithoutLastGood(t *testing.T) {
+	root := t.TempDir()
+	cfg := testConfig(root)
+	cfg.RunCommand = nil
+	cfg.BinDir = filepath.Join(root, ".bin")
+	installLocal(t, cfg, "v1", map[string]string{
+		"go.mod":  "module probe\n\ngo 1.21\n",
+		"main.go": "package main\n\nfunc main() { broken }\n",
+	})
+
+	_, err := New(cfg).command()
+	if err == nil || !strings.Contains(err.Error(), "no previous binary") {
+		t.Errorf("command = %v, want a build failure without fallback", err)
+	}
+}
@@ -0,0 +1,138 @@
+package updater
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// Release identifies one published version of the watched subtree.
+type Release struct {
+	Version string
+	// branch or tag the version was resolved from (empty = default branch)
+	Ref string
+}
+
+// ChannelKind says how a Channel picks the ref to follow.
+type ChannelKind int
+
+const (
+	// ChannelDefault follows the default branch of the repository.
+	ChannelDefault ChannelKind = iota
+	// ChannelBranch follows the branch Channel.Name.
+	ChannelBranch
+	// ChannelTag follows the newest tag matching the glob Channel.Name.
+	ChannelTag
+	// ChannelRelease follows the latest GitHub release.
+	ChannelRelease
+)
+
+// Channel is the line of versions a node follows, e.g. a canary following
+// main while production follows tagged releases.
+type Channel struct {
+	Kind ChannelKind
+	Name string
+}
+
+// ParseChannel parses a channel spec:
+//
+//	""                default branch
+//	branch:main       a branch (a plain name like "main" works too)
+//	tag:im-v*         newest tag matching the pattern (a plain pattern
+//	                  containing *, ? or [ works too)
+//	release           latest GitHub release
+func ParseChannel(spec string) (Channel, error) {
+	kind, name, hasKind := strings.Cut(spec, ":")
+	if !hasKind {
+		switch {
+		case spec == "":
+			return Channel{}, nil
+		case spec == "release" || spec == "latest":
+			return Channel{Kind: ChannelRelease}, nil
+		case strings.ContainsAny(spec, "*?["):
+			kind, name = "tag", spec
+		default:
+			kind, name = "branch", spec
+		}
+	}
+
+	switch kind {
+	case "branch":
+		if name == "" {
+			return Channel{}, fmt.Errorf("channel %q: branch name is empty", spec)
+		}
+		return Channel{Kind: ChannelBranch, Name: name}, nil
+	case "tag":
+		if _, err := path.Match(name, ""); err != nil || name == "" {
+			return Channel{}, fmt.Errorf("channel %q: invalid tag pattern", spec)
+		}
+		return Channel{Kind: ChannelTag, Name: name}, nil
+	case "release":
+		if name != "" && name != "latest" {
+			return Channel{}, fmt.Errorf("channel %q: only the latest release can be followed", spec)
+		}
+		return Channel{Kind: ChannelRelease}, nil
+	}
+	return Channel{}, fmt.Errorf("channel %q: unknown kind %q", spec, kind)
+}
+
+func (c Channel) String() string {
+	switch c.Kind {
+	case ChannelBranch:
+		return "branch " + c.Name
+	case ChannelTag:
+		return "tags matching " + c.Name
+	case ChannelRelease:
+		return "latest release"
+	}
+	return "default branch"
+}
+
+// newestTag returns the highest of tags matching pattern in version order,
+// or "" if none matches.
+func newestTag(tags []string, pattern string) string {
+	newest := ""
+	for _, t := range tags {
+		if ok, _ := path.Match(pattern, t); !ok {
+			continue
+		}
+		if newest == "" || compareVersions(t, newest) > 0 {
+			newest = t
+		}
+	}
+	return newest
+}
+
+// compareVersions compares two tag names, treating runs of digits as numbers
+// so that im-v1.10 sorts after im-v1.9.
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		ca, ra := versionChunk(a)
+		cb, rb := versionChunk(b)
+		if c := compareChunk(ca, cb); c != 0 {
+			return c
+		}
+		a, b = ra, rb
+	}
+	return len(a) - len(b)
+}
+
+func versionChunk(s string) (chunk, rest string) {
+	digit := unicode.IsDigit(rune(s[0]))
+	i := 1
+	for i < len(s) && unicode.IsDigit(rune(s[i])) == digit {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func compareChunk(a, b string) int {
+	if unicode.IsDigit(rune(a[0])) && unicode.IsDigit(rune(b[0])) {
+		a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+		if len(a) != len(b) {
+			return len(a) - len(b)
+		}
+	}
+	return strings.Compare(a, b)
+}
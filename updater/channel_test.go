@@ -0,0 +1,40 @@
+package updater
+
+import "testing"
+
+func TestParseChannel(t *testing.T) {
+	cases := map[string]Channel{
+		"":                {},
+		"main":            {Kind: ChannelBranch, Name: "main"},
+		"branch:canary":   {Kind: ChannelBranch, Name: "canary"},
+		"im-v*":           {Kind: ChannelTag, Name: "im-v*"},
+		"tag:server-v1.*": {Kind: ChannelTag, Name: "server-v1.*"},
+		"release":         {Kind: ChannelRelease},
+		"release:latest":  {Kind: ChannelRelease},
+	}
+	for spec, want := range cases {
+		got, err := ParseChannel(spec)
+		if err != nil || got != want {
+			t.Errorf("ParseChannel(%q) = %+v, %v; want %+v", spec, got, err, want)
+		}
+	}
+
+	for _, spec := range []string{"branch:", "tag:", "tag:[", "release:v1", "commit:abc"} {
+		if _, err := ParseChannel(spec); err == nil {
+			t.Errorf("ParseChannel(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestNewestTag(t *testing.T) {
+	tags := []string{"im-v1.9", "server-v3.0", "im-v1.10", "im-v1.2", "other"}
+	if got := newestTag(tags, "im-v*"); got != "im-v1.10" {
+		t.Errorf("newestTag = %q, want im-v1.10", got)
+	}
+	if got := newestTag(tags, "im-v1.?"); got != "im-v1.9" {
+		t.Errorf("newestTag = %q, want im-v1.9", got)
+	}
+	if got := newestTag(tags, "nope-*"); got != "" {
+		t.Errorf("newestTag = %q, want none", got)
+	}
+}
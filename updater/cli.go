@@ -0,0 +1,159 @@
+package updater
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Exit codes of Main. ExitUpdateAvailable is only used by the check command.
+const (
+	ExitOK              = 0
+	ExitUpdateAvailable = 1
+	ExitError           = 2
+)
+
+var commands = []struct {
+	name, help string
+}{
+	{"start", "check for an update, install it and run (default)"},
+	{"run", "run the local version without checking for an update first"},
+	{"update", "install the remote version if it differs, without running it"},
+	{"check", "exit with 1 if an update is available, 0 if not"},
+	{"status", "print local and remote version and the last update result"},
+	{"rollback", "restore the previous version (stop the running bootstrapper first)"},
+}
+
+// Main runs the bootstrapper command line: an optional subcommand followed by
+// the flags of BindFlags, whose defaults are taken from cfg. An empty
+// cfg.PublicKey defaults to $UPDATE_PUBLIC_KEY. It returns the process exit
+// code.
+func Main(cfg Config, args []string) int {
+	prog := filepath.Base(os.Args[0])
+	cmd := "start"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	known := false
+	for _, c := range commands {
+		known = known || c.name == cmd
+	}
+	if !known {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		usage(os.Stderr, prog)
+		return ExitError
+	}
+
+	// the key pinned by the node, matching the UPDATE_SIGNING_KEY secret of
+	// the workflows that sign the manifests
+	if cfg.PublicKey == "" {
+		cfg.PublicKey = os.Getenv("UPDATE_PUBLIC_KEY")
+	}
+	fs := flag.NewFlagSet(prog+" "+cmd, flag.ExitOnError)
+	BindFlags(fs, &cfg)
+	fs.Usage = func() {
+		usage(fs.Output(), prog)
+		fmt.Fprintln(fs.Output(), "\nFlags:")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	u := New(cfg)
+	var err error
+	switch cmd {
+	case "start":
+		err = u.Run()
+	case "run":
+		err = u.RunLocal()
+	case "update":
+		var updated bool
+		if updated, err = u.Update(); err == nil && !updated {
+			log.Printf("%s is up to date.", cfg.LocalDir)
+		}
+	case "check":
+		rel, available, checkErr := u.Check()
+		if checkErr != nil {
+			err = checkErr
+			break
+		}
+		if available {
+			fmt.Printf("update available: %s\n", rel.Version)
+			return ExitUpdateAvailable
+		}
+		fmt.Println("up to date")
+	case "status":
+		err = u.Status(os.Stdout)
+	case "rollback":
+		err = u.Rollback()
+	}
+	if err != nil {
+		log.Printf("%s: %v", cmd, err)
+		return ExitError
+	}
+	return ExitOK
+}
+
+func usage(w io.Writer, prog string) {
+	fmt.Fprintf(w, "Usage: %s [command] [flags]\n\nCommands:\n", prog)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.help)
+	}
+}
+
+// Status prints the local and remote version, the previous version kept for
+// rollback and the last update result to w. An unreachable source is
+// reported but isn't an error.
+func (u *Updater) Status(w io.Writer) error {
+	if err := u.init(); err != nil {
+		return err
+	}
+
+	local, _ := u.readLocalVersion()
+	if local == "" {
+		local = "(none)"
+	}
+	if ref := u.readLocalRef(); ref != "" {
+		local += " (" + ref + ")"
+	}
+	fmt.Fprintf(w, "local:    %s\n", local)
+
+	remote, available, err := u.pending()
+	switch {
+	case err != nil:
+		fmt.Fprintf(w, "remote:   unavailable: %v\n", err)
+	case available:
+		fmt.Fprintf(w, "remote:   %s (update available)\n", formatRelease(remote))
+	default:
+		fmt.Fprintf(w, "remote:   %s\n", formatRelease(remote))
+	}
+	fmt.Fprintf(w, "source:   %v\n", u.src)
+
+	st := u.loadState()
+	if st.Previous != "" {
+		fmt.Fprintf(w, "previous: %s\n", st.Previous)
+	}
+	if r := st.LastUpdate; r != nil {
+		fmt.Fprintf(w, "last update: %s %s -> %s at %s", r.Result, r.From, r.To, r.Time.Local().Format(time.RFC3339))
+		if r.Reason != "" {
+			fmt.Fprintf(w, " (%s)", r.Reason)
+		}
+		fmt.Fprintln(w)
+	}
+	for version, reason := range st.Rejected {
+		fmt.Fprintf(w, "rejected: %s (%s)\n", version, reason)
+	}
+	return nil
+}
+
+func formatRelease(r Release) string {
+	if r.Ref == "" {
+		return r.Version
+	}
+	return fmt.Sprintf("%s (%s)", r.Version, r.Ref)
+}
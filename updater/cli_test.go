@@ -0,0 +1,109 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// runMain calls Main and returns its exit code and what it printed to stdout.
+func runMain(t *testing.T, cfg Config, args []string) (int, string) {
+	t.Helper()
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	code := Main(cfg, args)
+	os.Stdout = stdout
+
+	b, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, string(b)
+}
+
+// interruptWhen sends SIGINT to the test process once the file p exists,
+// which stops a supervising Main.
+func interruptWhen(p string) {
+	go func() {
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if _, err := os.Stat(p); err == nil {
+				break
+			}
+		}
+		syscall.Kill(os.Getpid(), syscall.SIGINT)
+	}()
+}
+
+func TestMainCommands(t *testing.T) {
+	root := t.TempDir()
+	remote := filepath.Join(root, "remote")
+	cfg := testConfig(root)
+	cfg.Source = remote
+	started := filepath.Join(root, "started")
+
+	// publish makes version the one the local source offers
+	publish := func(version string) {
+		t.Helper()
+		err := writeTree(remote, map[string]string{
+			"im_main/.current_version":        version,
+			"im_main/instance_manager/run.sh": "echo " + version + " >> " + started + "\nwhile true; do sleep 0.05; done\n",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish("v1")
+
+	cases := []struct {
+		name   string
+		args   []string
+		before func()
+		code   int
+		stdout string // must be contained in the output
+		local  string // local version afterwards
+	}{
+		{name: "unknown command", args: []string{"frobnicate"}, code: ExitError},
+		{name: "invalid config", args: []string{"check", "-local-dir="}, code: ExitError},
+		{name: "check without local version", args: []string{"check"}, code: ExitUpdateAvailable, stdout: "update available: v1"},
+		{name: "update", args: []string{"update"}, code: ExitOK, local: "v1"},
+		{name: "check up to date", args: []string{"check"}, code: ExitOK, stdout: "up to date", local: "v1"},
+		{name: "update to v2", args: []string{"update", "-keep-versions=1"}, before: func() { publish("v2") }, code: ExitOK, local: "v2"},
+		{name: "status", args: []string{"status"}, code: ExitOK, stdout: "local:    v2\nremote:   v2\nsource:   " + remote + "\nprevious: v1\n", local: "v2"},
+		{name: "rollback", args: []string{"rollback"}, code: ExitOK, local: "v1"},
+		{name: "rolled back version isn't offered", args: []string{"check"}, code: ExitOK, stdout: "up to date", local: "v1"},
+		{name: "status after rollback", args: []string{"status"}, code: ExitOK, stdout: "rejected: v2 (rolled back manually)", local: "v1"},
+		{name: "nothing to roll back to", args: []string{"rollback"}, code: ExitError, local: "v1"},
+		{name: "run", args: []string{"run"}, before: func() { interruptWhen(started) }, code: ExitOK, local: "v1"},
+		{name: "start installs and runs", args: nil, before: func() {
+			os.Remove(started)
+			publish("v3")
+			interruptWhen(started)
+		}, code: ExitOK, local: "v3"},
+	}
+	for _, c := range cases {
+		if c.before != nil {
+			c.before()
+		}
+		code, stdout := runMain(t, cfg, c.args)
+		if code != c.code {
+			t.Errorf("%s: exit code %d, want %d", c.name, code, c.code)
+		}
+		if !strings.Contains(stdout, c.stdout) {
+			t.Errorf("%s: stdout = %q, want it to contain %q", c.name, stdout, c.stdout)
+		}
+		if local, _ := New(cfg).readLocalVersion(); local != c.local {
+			t.Errorf("%s: local version = %q, want %q", c.name, local, c.local)
+		}
+	}
+	if got := strings.Join(readLines(started), " "); got != "v3" {
+		t.Errorf("started = %q, want v3 run by start", got)
+	}
+}
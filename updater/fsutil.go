@@ -0,0 +1,97 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// moveDirAtomic tries to rename src->dest. If rename fails with EXDEV, it copies src->dest and removes src.
+func moveDirAtomic(src, dest string) error {
+	// try rename first
+	err := os.Rename(src, dest)
+	if err == nil {
+		return nil
+	}
+
+	// if it's not a link error with EXDEV, return the error
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		if pe, ok := linkErr.Err.(syscall.Errno); ok && pe == syscall.EXDEV {
+			// cross-device link error -> do copy
+			if err := copyDir(src, dest); err != nil {
+				return fmt.Errorf("copy during EXDEV fallback failed: %w", err)
+			}
+			// remove original
+			if err := os.RemoveAll(src); err != nil {
+				return fmt.Errorf("failed to remove original after copy: %w", err)
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+// copyDir recursively copies src to dest, preserving modes and symlinks.
+func copyDir(src, dest string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		// directory
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+
+		// handle symlinks
+		if info.Mode()&os.ModeSymlink != 0 {
+			linkDest, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			// ensure parent dir exists
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			// create symlink
+			return os.Symlink(linkDest, target)
+		}
+
+		// regular file
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		if _, err := io.Copy(out, in); err != nil {
+			return err
+		}
+		// set file mode explicitly (in case umask etc)
+		return os.Chmod(target, info.Mode())
+	})
+}
@@ -0,0 +1,209 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenEnv is read for a GitHub token when no token file is configured.
+const tokenEnv = "GITHUB_TOKEN"
+
+// maxRateLimitWait is the longest we sleep inside a request for a rate limit
+// to reset; longer waits fail the request and later ones until the reset.
+const maxRateLimitWait = 30 * time.Second
+
+// RateLimitError is returned while GitHub refuses requests because the rate
+// limit is exhausted. It matches ErrRateLimited.
+type RateLimitError struct {
+	// when GitHub accepts requests again
+	Reset time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("github rate limit exceeded, resets at %s", e.Reset.Format(time.RFC3339))
+}
+
+func (e *RateLimitError) Is(target error) bool { return target == ErrRateLimited }
+
+// cachedResponse is a GitHub API answer kept for conditional requests.
+type cachedResponse struct {
+	ETag string `json:"etag"`
+	Body []byte `json:"body"`
+}
+
+// loadToken returns the token from tokenFile, or from $GITHUB_TOKEN when
+// tokenFile is empty. No token means anonymous access.
+func loadToken(tokenFile string) (string, error) {
+	if tokenFile == "" {
+		return strings.TrimSpace(os.Getenv(tokenEnv)), nil
+	}
+	b, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("reading token file: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// authorize adds the token, if any, to req.
+func (s *GitHubSource) authorize(req *http.Request) {
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+}
+
+// apiGet fetches a GitHub API URL and returns the response body. Answers are
+// cached by ETag and revalidated with If-None-Match, which doesn't count
+// against the rate limit when nothing changed. A 404 is reported as
+// ErrRemoteVersionNotFound and an exhausted rate limit as *RateLimitError.
+func (s *GitHubSource) apiGet(ctx context.Context, apiURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, httpTimeout)
+	defer cancel()
+
+	if err := s.waitRateLimit(ctx); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.loadCache()
+	cached, hasCached := s.cache[apiURL]
+	s.mu.Unlock()
+
+	for retried := false; ; retried = true {
+		req, _ := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+		req.Header.Set("Accept", "application/vnd.github+json")
+		s.authorize(req)
+		if hasCached {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusNotModified && hasCached:
+			return cached.Body, nil
+
+		case resp.StatusCode == http.StatusNotFound:
+			return nil, ErrRemoteVersionNotFound
+
+		case resp.StatusCode < 300:
+			if etag := resp.Header.Get("ETag"); etag != "" {
+				s.storeCache(apiURL, cachedResponse{ETag: etag, Body: body})
+			}
+			return body, nil
+		}
+
+		if rl := s.rateLimited(resp); rl != nil {
+			if wait := time.Until(rl.Reset); !retried && wait <= maxRateLimitWait {
+				log.Printf("GitHub rate limit hit, retrying in %s", wait.Round(time.Second))
+				if err := s.waitRateLimit(ctx); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, rl
+		}
+		return nil, fmt.Errorf("github api error: %s - %s", resp.Status, string(body))
+	}
+}
+
+// rateLimited inspects a failed response. If GitHub refused it because of
+// the primary or secondary rate limit, it remembers when requests may be sent
+// again and returns the error.
+func (s *GitHubSource) rateLimited(resp *http.Response) *RateLimitError {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+
+	var reset time.Time
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		reset = time.Now().Add(time.Duration(secs) * time.Second)
+	} else if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		unix, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil {
+			// GitHub's primary limit window is one hour
+			unix = time.Now().Add(time.Hour).Unix()
+		}
+		reset = time.Unix(unix, 0)
+	} else if resp.StatusCode == http.StatusTooManyRequests {
+		// secondary limit without a hint: wait at least a minute
+		reset = time.Now().Add(time.Minute)
+	} else {
+		return nil
+	}
+
+	s.mu.Lock()
+	if reset.After(s.blockedUntil) {
+		s.blockedUntil = reset
+	}
+	s.mu.Unlock()
+	return &RateLimitError{Reset: reset}
+}
+
+// waitRateLimit sleeps until the rate limit resets if that is at most
+// maxRateLimitWait away, and fails right away otherwise.
+func (s *GitHubSource) waitRateLimit(ctx context.Context) error {
+	s.mu.Lock()
+	until := s.blockedUntil
+	s.mu.Unlock()
+
+	wait := time.Until(until)
+	if wait <= 0 {
+		return nil
+	}
+	if wait > maxRateLimitWait {
+		return &RateLimitError{Reset: until}
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// loadCache reads CacheFile on first use. s.mu must be held.
+func (s *GitHubSource) loadCache() {
+	if s.cache != nil {
+		return
+	}
+	s.cache = make(map[string]cachedResponse)
+	if s.CacheFile == "" {
+		return
+	}
+	b, err := os.ReadFile(s.CacheFile)
+	if err == nil {
+		_ = json.Unmarshal(b, &s.cache)
+	}
+}
+
+func (s *GitHubSource) storeCache(apiURL string, r cachedResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache[apiURL] = r
+	if s.CacheFile == "" {
+		return
+	}
+	b, err := json.Marshal(s.cache)
+	if err == nil {
+		err = os.WriteFile(s.CacheFile, b, 0644)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to write %s: %v", s.CacheFile, err)
+	}
+}
@@ -0,0 +1,90 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAPIGetConditionalRequest(t *testing.T) {
+	var requests, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("body"))
+	}))
+	defer srv.Close()
+
+	cache := filepath.Join(t.TempDir(), "cache.json")
+	for i := 0; i < 3; i++ {
+		// a fresh source per round checks that the cache survives restarts
+		s := &GitHubSource{Token: "secret", CacheFile: cache}
+		body, err := s.apiGet(context.Background(), srv.URL)
+		if err != nil || string(body) != "body" {
+			t.Fatalf("round %d: apiGet = %q, %v", i, body, err)
+		}
+	}
+	if requests != 3 || notModified != 2 {
+		t.Errorf("requests = %d, not modified = %d; want 3 and 2", requests, notModified)
+	}
+}
+
+func TestAPIGetRateLimit(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	s := &GitHubSource{}
+	for i := 0; i < 2; i++ {
+		_, err := s.apiGet(context.Background(), srv.URL)
+		if !errors.Is(err, ErrRateLimited) {
+			t.Fatalf("err = %v, want ErrRateLimited", err)
+		}
+		if errors.Is(err, ErrRemoteVersionNotFound) {
+			t.Fatal("rate limit reported as not found")
+		}
+	}
+	if requests != 1 {
+		t.Errorf("%d requests sent, want 1 until the limit resets", requests)
+	}
+}
+
+func TestAPIGetRetryAfter(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	body, err := (&GitHubSource{}).apiGet(context.Background(), srv.URL)
+	if err != nil || string(body) != "ok" {
+		t.Fatalf("apiGet = %q, %v", body, err)
+	}
+	if requests != 2 {
+		t.Errorf("%d requests sent, want 2", requests)
+	}
+}
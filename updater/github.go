@@ -0,0 +1,247 @@
+package updater
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// githubAPI is the base URL of the GitHub REST API.
+var githubAPI = "https://api.github.com"
+
+type ghContent struct {
+	Type     string `json:"type"`
+	Encoding string `json:"encoding"`
+	Size     int    `json:"size"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	Sha      string `json:"sha"`
+}
+
+// GitHubSource fetches the watched subtree from a GitHub repository through
+// the REST API, falling back to a shallow git clone if the zipball download
+// fails for other reasons than a missing repository or the rate limit.
+type GitHubSource struct {
+	Owner       string
+	Repo        string
+	Subdir      string
+	VersionPath string
+	Channel     Channel
+	// optional token; anonymous requests are limited to 60 per hour
+	Token string
+	// file persisting ETags and answers across restarts (empty = memory only)
+	CacheFile string
+
+	mu           sync.Mutex
+	cache        map[string]cachedResponse
+	blockedUntil time.Time
+}
+
+// Version resolves the channel to a ref and returns the content of the
+// version file at that ref. If the repo has no version file the ref's latest
+// commit SHA is used instead.
+func (s *GitHubSource) Version(ctx context.Context) (Release, error) {
+	ref, err := s.resolveRef(ctx)
+	if err != nil {
+		return Release{}, err
+	}
+
+	v, err := s.fetchRemoteVersionContent(ctx, s.VersionPath, ref)
+	if !errors.Is(err, ErrRemoteVersionNotFound) {
+		return Release{Version: v, Ref: ref}, err
+	}
+
+	log.Printf("Remote %s not found, using the latest commit SHA as version.", s.VersionPath)
+	sha, shaErr := s.fetchLatestCommitSHA(ctx, ref)
+	if shaErr != nil {
+		return Release{}, fmt.Errorf("%w (and could not fetch latest commit SHA: %v)", err, shaErr)
+	}
+	return Release{Version: sha, Ref: ref}, nil
+}
+
+// Fetch downloads the subtree at rel.Ref into dest.
+func (s *GitHubSource) Fetch(ctx context.Context, rel Release, dest string) error {
+	// Try zipball download first
+	zipErr := s.downloadAndExtractZipball(ctx, rel.Ref, dest)
+	if zipErr == nil {
+		return nil
+	}
+
+	// A missing zipball means a missing or private repository, which a clone
+	// can't get either. A rate limit is waited out: cloning instead would
+	// download the whole repository again.
+	if errors.Is(zipErr, ErrZipballNotFound) {
+		return fmt.Errorf("%w\n\nThe repository zipball was not found. Ensure the repository %s/%s exists and is public", ErrZipballNotFound, s.Owner, s.Repo)
+	}
+	if errors.Is(zipErr, ErrRateLimited) {
+		return zipErr
+	}
+
+	// Otherwise try git-clone fallback
+	log.Println("Zipball download failed, attempting git clone fallback...")
+	_ = os.RemoveAll(dest)
+	// the version here is the version file's content, not a commit to pin
+	git := &GitSource{URL: fmt.Sprintf("https://github.com/%s/%s.git", s.Owner, s.Repo), Subdir: s.Subdir}
+	if err := git.Fetch(ctx, Release{Ref: rel.Ref}, dest); err != nil {
+		return fmt.Errorf("git clone fallback failed: %w (original zipball error: %v)", err, zipErr)
+	}
+	return nil
+}
+
+func (s *GitHubSource) String() string {
+	return fmt.Sprintf("github.com/%s/%s (%s)", s.Owner, s.Repo, s.Channel)
+}
+
+// resolveRef returns the branch or tag the channel currently points to, or ""
+// for the default branch.
+func (s *GitHubSource) resolveRef(ctx context.Context) (string, error) {
+	switch s.Channel.Kind {
+	case ChannelBranch:
+		return s.Channel.Name, nil
+
+	case ChannelTag:
+		names, err := s.listTags(ctx)
+		if err != nil {
+			return "", fmt.Errorf("listing tags: %w", err)
+		}
+		tag := newestTag(names, s.Channel.Name)
+		if tag == "" {
+			return "", fmt.Errorf("no tag matches %q: %w", s.Channel.Name, ErrRemoteVersionNotFound)
+		}
+		return tag, nil
+
+	case ChannelRelease:
+		var release struct {
+			TagName string `json:"tag_name"`
+		}
+		if err := s.getJSON(ctx, "releases/latest", &release); err != nil {
+			return "", fmt.Errorf("fetching latest release: %w", err)
+		}
+		return release.TagName, nil
+	}
+	return "", nil
+}
+
+// tagsPerPage is the page size of the tag listing, the most GitHub allows.
+const tagsPerPage = 100
+
+// listTags returns the names of all tags of the repository, reading page
+// after page until one isn't full.
+func (s *GitHubSource) listTags(ctx context.Context) ([]string, error) {
+	var names []string
+	for page := 1; ; page++ {
+		var tags []struct {
+			Name string `json:"name"`
+		}
+		if err := s.getJSON(ctx, fmt.Sprintf("tags?per_page=%d&page=%d", tagsPerPage, page), &tags); err != nil {
+			return nil, err
+		}
+		for _, t := range tags {
+			names = append(names, t.Name)
+		}
+		if len(tags) < tagsPerPage {
+			return names, nil
+		}
+	}
+}
+
+// getJSON decodes the answer of the repository API endpoint below
+// /repos/<owner>/<repo>/ into v.
+func (s *GitHubSource) getJSON(ctx context.Context, endpoint string, v any) error {
+	body, err := s.apiGet(ctx, fmt.Sprintf("%s/repos/%s/%s/%s", githubAPI, s.Owner, s.Repo, endpoint))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func (s *GitHubSource) fetchRemoteVersionContent(ctx context.Context, path, ref string) (string, error) {
+	endpoint := "contents/" + path
+	if ref != "" {
+		endpoint += "?ref=" + url.QueryEscape(ref)
+	}
+
+	var content ghContent
+	if err := s.getJSON(ctx, endpoint, &content); err != nil {
+		return "", err
+	}
+
+	if content.Encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(content.Content, "\n", ""))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(decoded)), nil
+	}
+	return strings.TrimSpace(content.Content), nil
+}
+
+func (s *GitHubSource) fetchLatestCommitSHA(ctx context.Context, ref string) (string, error) {
+	endpoint := "commits?per_page=1"
+	if ref != "" {
+		endpoint += "&sha=" + url.QueryEscape(ref)
+	}
+
+	var arr []struct {
+		SHA string `json:"sha"`
+	}
+	if err := s.getJSON(ctx, endpoint, &arr); err != nil {
+		return "", fmt.Errorf("fetching commits: %w", err)
+	}
+	if len(arr) == 0 || arr[0].SHA == "" {
+		return "", errors.New("no commits returned")
+	}
+	return arr[0].SHA, nil
+}
+
+// downloadAndExtractZipball downloads the repository zipball at ref and
+// extracts the watched subtree into dest.
+func (s *GitHubSource) downloadAndExtractZipball(ctx context.Context, ref, dest string) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*httpTimeout)
+	defer cancel()
+
+	zipURL := fmt.Sprintf("%s/repos/%s/%s/zipball", githubAPI, s.Owner, s.Repo)
+	if ref != "" {
+		zipURL += "/" + url.PathEscape(ref)
+	}
+	req, _ := http.NewRequestWithContext(ctx, "GET", zipURL, nil)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	// the redirect to codeload.github.com drops the Authorization header
+	s.authorize(req)
+
+	client := &http.Client{Timeout: 10 * httpTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrZipballNotFound
+	}
+	if rl := s.rateLimited(resp); rl != nil {
+		return rl
+	}
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to download zipball: %s - %s", resp.Status, string(body))
+	}
+
+	if err := extractArchive(resp.Body, s.Subdir, dest); err != nil {
+		return err
+	}
+
+	log.Println("Successfully downloaded", s.Subdir, "via zipball")
+	return nil
+}
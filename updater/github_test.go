@@ -0,0 +1,69 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveRefReadsAllTagPages(t *testing.T) {
+	// 150 tags, the newest matching one on the second page
+	var tags []map[string]string
+	for i := 149; i >= 0; i-- {
+		tags = append(tags, map[string]string{"name": fmt.Sprintf("server-v1.%d", i)})
+	}
+	tags = append(tags, map[string]string{"name": "im-v1.10"}, map[string]string{"name": "im-v1.9"})
+
+	var pages []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/owner/repo/tags" || r.URL.Query().Get("per_page") != "100" {
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		page := r.URL.Query().Get("page")
+		pages = append(pages, page)
+		var start int
+		fmt.Sscan(page, &start)
+		start = (start - 1) * 100
+		end := min(start+100, len(tags))
+		json.NewEncoder(w).Encode(tags[min(start, end):end])
+	}))
+	defer srv.Close()
+	defer func(api string) { githubAPI = api }(githubAPI)
+	githubAPI = srv.URL
+
+	s := &GitHubSource{Owner: "owner", Repo: "repo", Channel: Channel{Kind: ChannelTag, Name: "im-v*"}}
+	ref, err := s.resolveRef(context.Background())
+	if err != nil || ref != "im-v1.10" {
+		t.Fatalf("resolveRef = %q, %v; want im-v1.10", ref, err)
+	}
+	if fmt.Sprint(pages) != "[1 2]" {
+		t.Errorf("pages = %v, want 1 and 2", pages)
+	}
+}
+
+func TestFetchRateLimitedDoesNotClone(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+	defer func(api string) { githubAPI = api }(githubAPI)
+	githubAPI = srv.URL
+
+	s := &GitHubSource{Owner: "owner", Repo: "repo", Subdir: "im_main/instance_manager"}
+	err := s.Fetch(context.Background(), Release{Version: "v2", Ref: "main"}, filepath.Join(t.TempDir(), "instance_manager"))
+	if !errors.Is(err, ErrRateLimited) || strings.Contains(err.Error(), "git clone") {
+		t.Errorf("Fetch = %v, want the rate limit without a clone", err)
+	}
+}
@@ -0,0 +1,3 @@
+module foo/updater
+
+go 1.25.4
@@ -0,0 +1,77 @@
+package updater
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// NodeConfig locates the per-node JSON config of the managed process. It
+// lives outside LocalDir, so updates and rollbacks never overwrite local
+// edits, and the managed process finds it through the environment variable
+// Env.
+type NodeConfig struct {
+	// environment variable holding the path, read here and by the process
+	Env string
+	// path used while Env is unset, relative to the bootstrapper
+	Path string
+	// environment variable overriding the "listen" field of the file (empty
+	// = none), and the address used when neither sets one
+	ListenEnv     string
+	DefaultListen string
+}
+
+// Export sets Env to the absolute path of the config file, so the managed
+// process reads the same file regardless of its working directory, and
+// returns the address the process listens on. A missing file is fine, the
+// process uses its defaults then.
+func (n NodeConfig) Export() (listen string, err error) {
+	path := os.Getenv(n.Env)
+	if path == "" {
+		path = n.Path
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return "", err
+	}
+	if err := os.Setenv(n.Env, path); err != nil {
+		return "", err
+	}
+
+	var file struct {
+		Listen string `json:"listen"`
+	}
+	b, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, &file); err != nil {
+			return "", fmt.Errorf("parsing %s: %w", path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return "", err
+	}
+
+	listen = n.DefaultListen
+	if file.Listen != "" {
+		listen = file.Listen
+	}
+	if v := os.Getenv(n.ListenEnv); n.ListenEnv != "" && v != "" {
+		listen = v
+	}
+	return listen, nil
+}
+
+// LocalURL returns the URL of path on an HTTP server listening on the
+// address listen (as given to net.Listen), reached from this machine.
+func LocalURL(listen, path string) (string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("listen address %q: %w", listen, err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + path, nil
+}
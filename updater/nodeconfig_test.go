@@ -0,0 +1,51 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNodeConfigExport(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	n := NodeConfig{Env: "TEST_NODE_CONFIG", Path: "node.json", ListenEnv: "TEST_NODE_LISTEN", DefaultListen: ":8000"}
+
+	t.Setenv("TEST_NODE_CONFIG", "")
+	t.Setenv("TEST_NODE_LISTEN", "")
+	if listen, err := n.Export(); err != nil || listen != ":8000" {
+		t.Errorf("Export without a file = %q, %v; want the default", listen, err)
+	}
+	if got := os.Getenv("TEST_NODE_CONFIG"); got != filepath.Join(dir, "node.json") {
+		t.Errorf("exported path = %q, want it absolute", got)
+	}
+
+	if err := os.WriteFile("node.json", []byte(`{"listen": "127.0.0.1:9000", "other": 1}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if listen, err := n.Export(); err != nil || listen != "127.0.0.1:9000" {
+		t.Errorf("Export = %q, %v; want the file's address", listen, err)
+	}
+	t.Setenv("TEST_NODE_LISTEN", ":9001")
+	if listen, err := n.Export(); err != nil || listen != ":9001" {
+		t.Errorf("Export = %q, %v; want the environment's address", listen, err)
+	}
+}
+
+func TestLocalURL(t *testing.T) {
+	cases := map[string]string{
+		":8000":          "http://localhost:8000/system",
+		"0.0.0.0:8000":   "http://localhost:8000/system",
+		"[::]:8000":      "http://localhost:8000/system",
+		"10.0.0.5:8080":  "http://10.0.0.5:8080/system",
+		"[fe80::1]:8080": "http://[fe80::1]:8080/system",
+	}
+	for listen, want := range cases {
+		if got, err := LocalURL(listen, "/system"); err != nil || got != want {
+			t.Errorf("LocalURL(%q) = %q, %v; want %q", listen, got, err, want)
+		}
+	}
+	if _, err := LocalURL("8000", "/system"); err == nil {
+		t.Error("LocalURL accepted an address without port")
+	}
+}
@@ -0,0 +1,300 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// UpdateSource is where new versions of the watched subtree come from.
+type UpdateSource interface {
+	// Version returns the currently published version.
+	Version(ctx context.Context) (Release, error)
+	// Fetch places the subtree of rel into dest, which must not exist yet.
+	Fetch(ctx context.Context, rel Release, dest string) error
+}
+
+// NewSource builds the UpdateSource described by cfg.Source:
+//
+//	""                          GitHub repository cfg.Owner/cfg.Repo
+//	https://host/servernet.zip  archive (zip or tar.gz) on an HTTP(S) mirror;
+//	                            the version file is cfg.RemoteVersionPath
+//	                            relative to the archive URL
+//	file:///srv/ServerNet       local checkout (a plain path works too)
+//	git+https://host/repo.git   any git remote (also git@host:repo, ssh://)
+//
+// cfg.Channel is only supported by the GitHub and git sources; a git remote
+// can't follow GitHub releases.
+func NewSource(cfg Config) (UpdateSource, error) {
+	channel, err := ParseChannel(cfg.Channel)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := cfg.Source
+	switch {
+	case spec == "":
+		token, err := loadToken(cfg.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		return &GitHubSource{
+			Owner:       cfg.Owner,
+			Repo:        cfg.Repo,
+			Subdir:      cfg.Subdir,
+			VersionPath: cfg.RemoteVersionPath,
+			Channel:     channel,
+			Token:       token,
+			CacheFile:   cfg.GitHubCache,
+		}, nil
+
+	case strings.HasPrefix(spec, "git+"), strings.HasPrefix(spec, "git@"), strings.HasPrefix(spec, "ssh://"), strings.HasSuffix(spec, ".git"):
+		if channel.Kind == ChannelRelease {
+			return nil, errors.New("git sources can't follow GitHub releases, use a tag pattern instead")
+		}
+		return &GitSource{URL: strings.TrimPrefix(spec, "git+"), Subdir: cfg.Subdir, Channel: channel}, nil
+	}
+
+	if channel.Kind != ChannelDefault {
+		return nil, fmt.Errorf("source %q doesn't support channels", spec)
+	}
+	switch {
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		archive, err := url.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source URL: %w", err)
+		}
+		version := *archive
+		version.Path = path.Join(path.Dir(archive.Path), cfg.RemoteVersionPath)
+		version.RawQuery = ""
+		return &HTTPSource{ArchiveURL: archive.String(), VersionURL: version.String(), Subdir: cfg.Subdir}, nil
+
+	default:
+		root := strings.TrimPrefix(spec, "file://")
+		if _, err := os.Stat(root); err != nil {
+			return nil, fmt.Errorf("unknown update source %q: %w", spec, err)
+		}
+		return &LocalSource{Root: root, Subdir: cfg.Subdir, VersionPath: cfg.RemoteVersionPath}, nil
+	}
+}
+
+// HTTPSource downloads an archive from any HTTP(S) server, e.g. a LAN mirror.
+type HTTPSource struct {
+	ArchiveURL string
+	VersionURL string
+	// path of the subtree inside the archive (empty = whole archive)
+	Subdir string
+}
+
+func (s *HTTPSource) Version(ctx context.Context) (Release, error) {
+	ctx, cancel := context.WithTimeout(ctx, httpTimeout)
+	defer cancel()
+
+	resp, err := httpGet(ctx, s.VersionURL)
+	if err != nil {
+		return Release{}, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return Release{}, err
+	}
+	return Release{Version: strings.TrimSpace(string(b))}, nil
+}
+
+func (s *HTTPSource) Fetch(ctx context.Context, _ Release, dest string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*httpTimeout)
+	defer cancel()
+
+	resp, err := httpGet(ctx, s.ArchiveURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := extractArchive(resp.Body, s.Subdir, dest); err != nil {
+		return err
+	}
+	log.Println("Successfully downloaded", s.Subdir, "from", s.ArchiveURL)
+	return nil
+}
+
+func (s *HTTPSource) String() string { return s.ArchiveURL }
+
+func httpGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", url, ErrRemoteVersionNotFound)
+	}
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s - %s", url, resp.Status, string(body))
+	}
+	return resp, nil
+}
+
+// LocalSource copies the subtree from a directory on this machine, e.g. a
+// checkout on a shared volume.
+type LocalSource struct {
+	Root        string
+	Subdir      string
+	VersionPath string
+}
+
+func (s *LocalSource) Version(ctx context.Context) (Release, error) {
+	b, err := os.ReadFile(filepath.Join(s.Root, s.VersionPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return Release{}, ErrRemoteVersionNotFound
+	}
+	if err != nil {
+		return Release{}, err
+	}
+	return Release{Version: strings.TrimSpace(string(b))}, nil
+}
+
+func (s *LocalSource) Fetch(ctx context.Context, _ Release, dest string) error {
+	src := filepath.Join(s.Root, s.Subdir)
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("local source does not contain %s: %w", s.Subdir, err)
+	}
+	if err := copyDir(src, dest); err != nil {
+		return err
+	}
+	log.Println("Successfully copied", s.Subdir, "from", s.Root)
+	return nil
+}
+
+func (s *LocalSource) String() string { return s.Root }
+
+// GitSource clones any git remote. Its version is the commit the channel's
+// ref points to.
+type GitSource struct {
+	URL     string
+	Subdir  string
+	Channel Channel
+}
+
+func (s *GitSource) Version(ctx context.Context) (Release, error) {
+	ctx, cancel := context.WithTimeout(ctx, httpTimeout)
+	defer cancel()
+
+	args := []string{"ls-remote", s.URL, "HEAD"}
+	switch s.Channel.Kind {
+	case ChannelBranch:
+		args = []string{"ls-remote", s.URL, "refs/heads/" + s.Channel.Name}
+	case ChannelTag:
+		args = []string{"ls-remote", "--tags", s.URL}
+	}
+	out, err := exec.CommandContext(ctx, "git", args...).Output()
+	if err != nil {
+		return Release{}, fmt.Errorf("git ls-remote failed: %w", err)
+	}
+
+	if s.Channel.Kind != ChannelTag {
+		fields := strings.Fields(string(out))
+		if len(fields) == 0 {
+			return Release{}, ErrRemoteVersionNotFound
+		}
+		return Release{Version: fields[0], Ref: s.Channel.Name}, nil
+	}
+
+	// tag -> commit; annotated tags are listed twice, the "^{}" line holds
+	// the commit instead of the tag object
+	commits := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		sha, ref, ok := strings.Cut(strings.TrimSpace(line), "\t")
+		if !ok {
+			continue
+		}
+		tag, peeled := strings.CutSuffix(strings.TrimPrefix(ref, "refs/tags/"), "^{}")
+		if _, seen := commits[tag]; !seen || peeled {
+			commits[tag] = sha
+		}
+	}
+	tags := make([]string, 0, len(commits))
+	for t := range commits {
+		tags = append(tags, t)
+	}
+	tag := newestTag(tags, s.Channel.Name)
+	if tag == "" {
+		return Release{}, fmt.Errorf("no tag matches %q: %w", s.Channel.Name, ErrRemoteVersionNotFound)
+	}
+	return Release{Version: commits[tag], Ref: tag}, nil
+}
+
+// Fetch shallow-clones the repository at rel.Ref and moves the watched
+// subtree to dest. A non-empty rel.Version is the commit to install: if the
+// ref moved on since Version resolved it, that commit is fetched by its ID.
+func (s *GitSource) Fetch(ctx context.Context, rel Release, dest string) error {
+	tmpDir, err := os.MkdirTemp("", "repo-clone-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Clone shallow to tmpDir.
+	args := []string{"clone", "--depth=1", "--single-branch"}
+	if rel.Ref != "" {
+		args = append(args, "--branch", rel.Ref)
+	}
+	if err := runGit(ctx, append(args, s.URL, tmpDir)...); err != nil {
+		return fmt.Errorf("git clone failed: %w", err)
+	}
+
+	if rel.Version != "" {
+		head, err := exec.CommandContext(ctx, "git", "-C", tmpDir, "rev-parse", "HEAD").Output()
+		if err != nil {
+			return fmt.Errorf("git rev-parse failed: %w", err)
+		}
+		if strings.TrimSpace(string(head)) != rel.Version {
+			log.Printf("%s moved on to %.12s, fetching version %s", s.URL, head, rel.Version)
+			if err := runGit(ctx, "-C", tmpDir, "fetch", "--depth=1", "origin", rel.Version); err != nil {
+				return fmt.Errorf("fetching commit %s failed: %w", rel.Version, err)
+			}
+			if err := runGit(ctx, "-C", tmpDir, "checkout", "--quiet", "--detach", rel.Version); err != nil {
+				return fmt.Errorf("checking out commit %s failed: %w", rel.Version, err)
+			}
+		}
+	}
+
+	src := filepath.Join(tmpDir, s.Subdir)
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("cloned repo does not contain %s: %w", s.Subdir, err)
+	}
+
+	if err := moveDirAtomic(src, dest); err != nil {
+		return fmt.Errorf("failed to move cloned %s: %w", s.Subdir, err)
+	}
+
+	log.Println("Successfully downloaded", s.Subdir, "via git clone")
+	return nil
+}
+
+// runGit runs git with args, its output going to ours.
+func runGit(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func (s *GitSource) String() string { return s.URL }
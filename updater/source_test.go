@@ -0,0 +1,179 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fetchFile fetches rel from src into a new directory and returns the
+// content of name in it.
+func fetchFile(t *testing.T, src UpdateSource, rel Release, name string) string {
+	t.Helper()
+	dest := filepath.Join(t.TempDir(), "instance_manager")
+	if err := src.Fetch(context.Background(), rel, dest); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dest, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestHTTPSource(t *testing.T) {
+	archive := makeZip(t, []entry{
+		{name: "ServerNet-main/im_main/instance_manager/run.sh", body: "v3"},
+		{name: "ServerNet-main/server_main/server_manager/run.sh", body: "other"},
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mirror/servernet.zip":
+			w.Write(archive)
+		case "/mirror/im_main/.current_version":
+			w.Write([]byte("v3\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	src, err := NewSource(Config{Source: srv.URL + "/mirror/servernet.zip?token=x", Subdir: "im_main/instance_manager", RemoteVersionPath: "im_main/.current_version"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := src.Version(context.Background())
+	if err != nil || rel.Version != "v3" {
+		t.Fatalf("Version = %+v, %v; want v3", rel, err)
+	}
+	if got := fetchFile(t, src, rel, "run.sh"); got != "v3" {
+		t.Errorf("run.sh = %q, want v3", got)
+	}
+
+	src.(*HTTPSource).VersionURL = srv.URL + "/mirror/missing"
+	if _, err := src.Version(context.Background()); !errors.Is(err, ErrRemoteVersionNotFound) {
+		t.Errorf("Version without a version file = %v, want ErrRemoteVersionNotFound", err)
+	}
+}
+
+func TestLocalSource(t *testing.T) {
+	root := t.TempDir()
+	if _, err := NewSource(Config{Source: filepath.Join(root, "missing")}); err == nil {
+		t.Error("NewSource accepted a missing directory")
+	}
+	src, err := NewSource(Config{Source: "file://" + root, Subdir: "im_main/instance_manager", RemoteVersionPath: "im_main/.current_version"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Version(context.Background()); !errors.Is(err, ErrRemoteVersionNotFound) {
+		t.Errorf("Version without a version file = %v, want ErrRemoteVersionNotFound", err)
+	}
+
+	err = writeTree(root, map[string]string{
+		"im_main/.current_version":        "v2\n",
+		"im_main/instance_manager/run.sh": "v2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := src.Version(context.Background())
+	if err != nil || rel.Version != "v2" {
+		t.Fatalf("Version = %+v, %v; want v2", rel, err)
+	}
+	if got := fetchFile(t, src, rel, "run.sh"); got != "v2" {
+		t.Errorf("run.sh = %q, want v2", got)
+	}
+}
+
+// gitRepo is a bare repository that commit publishes to.
+type gitRepo struct {
+	t          *testing.T
+	bare, work string
+}
+
+func newGitRepo(t *testing.T) *gitRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	root := t.TempDir()
+	r := &gitRepo{t: t, bare: filepath.Join(root, "servernet.git"), work: filepath.Join(root, "work")}
+	r.git(root, "init", "--quiet", "--bare", "--initial-branch=main", r.bare)
+	r.git(root, "clone", "--quiet", r.bare, r.work)
+	r.git(r.work, "checkout", "--quiet", "-b", "main")
+	return r
+}
+
+func (r *gitRepo) git(dir string, args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit pushes a commit setting run.sh of the subtree to content, tagged
+// with tag if not empty, and returns its ID.
+func (r *gitRepo) commit(content, tag string) string {
+	r.t.Helper()
+	if err := writeTree(r.work, map[string]string{"im_main/instance_manager/run.sh": content}); err != nil {
+		r.t.Fatal(err)
+	}
+	r.git(r.work, "add", "-A")
+	r.git(r.work, "commit", "--quiet", "-m", content)
+	r.git(r.work, "push", "--quiet", "origin", "main")
+	if tag != "" {
+		r.git(r.work, "tag", "-a", "-m", tag, tag)
+		r.git(r.work, "push", "--quiet", "origin", tag)
+	}
+	return r.git(r.work, "rev-parse", "HEAD")
+}
+
+func TestGitSource(t *testing.T) {
+	repo := newGitRepo(t)
+	v1 := repo.commit("v1", "im-v1.0")
+	v2 := repo.commit("v2", "")
+
+	src, err := NewSource(Config{Source: "git+file://" + repo.bare, Subdir: "im_main/instance_manager", Channel: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := src.Version(context.Background())
+	if err != nil || rel != (Release{Version: v2, Ref: "main"}) {
+		t.Fatalf("Version = %+v, %v; want %s on main", rel, err, v2)
+	}
+
+	// the branch moves on between Version and Fetch
+	repo.commit("v3", "")
+	if got := fetchFile(t, src, rel, "run.sh"); got != "v2" {
+		t.Errorf("run.sh = %q, want v2 that Version reported", got)
+	}
+
+	// annotated tags resolve to their commit
+	tags, err := NewSource(Config{Source: "git+file://" + repo.bare, Subdir: "im_main/instance_manager", Channel: "tag:im-v*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err = tags.Version(context.Background())
+	if err != nil || rel != (Release{Version: v1, Ref: "im-v1.0"}) {
+		t.Fatalf("Version = %+v, %v; want %s at im-v1.0", rel, err, v1)
+	}
+	if got := fetchFile(t, tags, rel, "run.sh"); got != "v1" {
+		t.Errorf("run.sh = %q, want v1", got)
+	}
+
+	// a commit the remote doesn't have isn't installed
+	rel.Version = strings.Repeat("0", 40)
+	if err := tags.Fetch(context.Background(), rel, filepath.Join(t.TempDir(), "instance_manager")); err == nil {
+		t.Error("Fetch of an unknown commit succeeded")
+	}
+}
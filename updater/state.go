@@ -0,0 +1,70 @@
+package updater
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+// updateState is persisted in StateFile so that rollbacks and the reason for
+// the last update outcome survive bootstrapper restarts.
+type updateState struct {
+	// version that was active before the current one, and its branch or tag
+	Previous    string `json:"previous,omitempty"`
+	PreviousRef string `json:"previous_ref,omitempty"`
+	// versions that failed their health check, with the reason
+	Rejected   map[string]string `json:"rejected,omitempty"`
+	LastUpdate *updateResult     `json:"last_update,omitempty"`
+}
+
+type updateResult struct {
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Ref    string    `json:"ref,omitempty"`
+	Time   time.Time `json:"time"`
+	Result string    `json:"result"` // installed, healthy, rolled_back, failed
+	Reason string    `json:"reason,omitempty"`
+}
+
+func (u *Updater) loadState() updateState {
+	var st updateState
+	b, err := os.ReadFile(u.cfg.StateFile)
+	if err == nil {
+		_ = json.Unmarshal(b, &st)
+	}
+	if st.Rejected == nil {
+		st.Rejected = make(map[string]string)
+	}
+	return st
+}
+
+func (u *Updater) saveState(st updateState) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(u.cfg.StateFile, b, 0644)
+}
+
+// recordResult stores the outcome of the most recent update.
+func (u *Updater) recordResult(from, to, result, reason string) {
+	st := u.loadState()
+	st.LastUpdate = &updateResult{From: from, To: to, Time: time.Now().UTC(), Result: result, Reason: reason}
+	if err := u.saveState(st); err != nil {
+		log.Printf("Warning: failed to write %s: %v", u.cfg.StateFile, err)
+	}
+}
+
+// markHealthy upgrades the last update result once the new version passed
+// its health check.
+func (u *Updater) markHealthy() {
+	st := u.loadState()
+	if st.LastUpdate == nil || st.LastUpdate.Result != "installed" {
+		return
+	}
+	st.LastUpdate.Result = "healthy"
+	if err := u.saveState(st); err != nil {
+		log.Printf("Warning: failed to write %s: %v", u.cfg.StateFile, err)
+	}
+}
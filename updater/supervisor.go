@@ -0,0 +1,213 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// child is one run of the managed process.
+type child struct {
+	cmd     *exec.Cmd
+	started time.Time
+	done    chan error // receives the result of cmd.Wait exactly once
+}
+
+// startChild launches the managed process inside LocalDir without waiting
+// for it.
+func (u *Updater) startChild() (*child, error) {
+	if _, err := os.Stat(u.cfg.LocalDir); err != nil {
+		return nil, fmt.Errorf("%s does not exist: %w", u.cfg.LocalDir, err)
+	}
+
+	args, err := u.command()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = u.cfg.LocalDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	cmd.Env = os.Environ()
+
+	log.Printf("Running `%s` in ./%s ...\n", strings.Join(args, " "), u.cfg.LocalDir)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s failed to start: %w", args[0], err)
+	}
+
+	c := &child{cmd: cmd, started: time.Now(), done: make(chan error, 1)}
+	go func() { c.done <- cmd.Wait() }()
+	return c, nil
+}
+
+// stop sends SIGINT and waits up to timeout for the child to exit before
+// killing it.
+func (c *child) stop(timeout time.Duration) {
+	if err := c.cmd.Process.Signal(syscall.SIGINT); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		log.Printf("Failed to signal child (killing instead): %v", err)
+		_ = c.cmd.Process.Kill()
+	}
+
+	select {
+	case <-c.done:
+	case <-time.After(timeout):
+		log.Printf("Child did not stop in %s, killing...", timeout)
+		_ = c.cmd.Process.Kill()
+		<-c.done
+	}
+}
+
+// supervise keeps the managed process running until ctx is cancelled. It
+// restarts the process with exponential backoff when it exits and, if
+// PollInterval is set, swaps in new versions as they are published. A fresh
+// version (including one installed at boot when updated is true) must pass
+// the health check or the previous version is restored.
+func (u *Updater) supervise(ctx context.Context, updated bool) error {
+	var (
+		current *child
+		done    <-chan error // nil while no child is running
+		restart <-chan time.Time
+		poll    <-chan time.Time
+		health  <-chan error // nil unless a new version is being verified
+		backoff = u.cfg.RestartBackoff
+	)
+
+	verify := func() {
+		if u.cfg.HealthURL == "" {
+			return
+		}
+		ch := make(chan error, 1)
+		go func() { ch <- u.healthCheck(ctx) }()
+		health = ch
+	}
+
+	start := func() {
+		c, err := u.startChild()
+		if err != nil {
+			log.Printf("Failed to start %s: %v (retrying in %s)", u.cfg.LocalDir, err, backoff)
+			restart = time.After(backoff)
+			backoff = nextBackoff(backoff, u.cfg.MaxRestartBackoff)
+			return
+		}
+		current, done, restart = c, c.done, nil
+	}
+
+	if u.cfg.PollInterval > 0 {
+		ticker := time.NewTicker(u.cfg.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	start()
+	if updated {
+		verify()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			if current != nil {
+				log.Printf("Shutting down %s...", u.cfg.LocalDir)
+				current.stop(u.cfg.StopTimeout)
+			}
+			return nil
+
+		case err := <-done:
+			// a child that ran for a while gets a fresh backoff
+			if time.Since(current.started) > u.cfg.MaxRestartBackoff {
+				backoff = u.cfg.RestartBackoff
+			}
+			current, done = nil, nil
+			log.Printf("%s exited (%v), restarting in %s", u.cfg.LocalDir, err, backoff)
+			restart = time.After(backoff)
+			backoff = nextBackoff(backoff, u.cfg.MaxRestartBackoff)
+
+		case <-restart:
+			start()
+
+		case err := <-health:
+			health = nil
+			if ctx.Err() != nil {
+				continue
+			}
+			version, _ := u.readLocalVersion()
+			if err == nil {
+				log.Printf("Version %s of %s is healthy.", version, u.cfg.LocalDir)
+				u.markHealthy()
+				continue
+			}
+
+			log.Printf("Version %s of %s failed its health check: %v", version, u.cfg.LocalDir, err)
+			if current != nil {
+				current.stop(u.cfg.StopTimeout)
+				current, done = nil, nil
+			}
+			if rbErr := u.rollback(err.Error()); rbErr != nil {
+				log.Printf("Rollback failed, keeping %s: %v", version, rbErr)
+				u.recordResult(version, version, "failed", fmt.Sprintf("%v; rollback failed: %v", err, rbErr))
+			}
+			backoff = u.cfg.RestartBackoff
+			start()
+
+		case <-poll:
+			staged, rel, cleanup, err := u.pollUpdate()
+			if err != nil {
+				log.Printf("Update check failed: %v", err)
+				continue
+			}
+			if staged == "" {
+				continue
+			}
+
+			log.Printf("New version %s downloaded, restarting %s...", rel.Version, u.cfg.LocalDir)
+			if current != nil {
+				current.stop(u.cfg.StopTimeout)
+				current, done = nil, nil
+			}
+			installErr := u.install(staged, rel)
+			cleanup()
+			if installErr != nil {
+				log.Printf("Failed to install new version: %v", installErr)
+			}
+			backoff = u.cfg.RestartBackoff
+			start()
+			if installErr == nil {
+				verify()
+			}
+		}
+	}
+}
+
+// pollUpdate compares the local and remote version and downloads the new
+// subtree if they differ. staged is empty when there is nothing to install.
+func (u *Updater) pollUpdate() (staged string, rel Release, cleanup func(), err error) {
+	remote, ok, err := u.pending()
+	if err != nil || !ok {
+		return "", Release{}, nil, err
+	}
+	localVersion, _ := u.readLocalVersion()
+
+	log.Printf("Update detected (local %q, remote %q). Downloading...", localVersion, remote.Version)
+	staged, cleanup, err = u.download(remote)
+	if err != nil {
+		if errors.Is(err, ErrVerification) {
+			u.recordResult(localVersion, remote.Version, "failed", err.Error())
+		}
+		return "", Release{}, nil, err
+	}
+	return staged, remote, cleanup, nil
+}
+
+func nextBackoff(cur, max time.Duration) time.Duration {
+	if next := cur * 2; next < max {
+		return next
+	}
+	return max
+}
@@ -0,0 +1,205 @@
+package updater
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSource publishes rel, whose subtree consists of files.
+type fakeSource struct {
+	mu      sync.Mutex
+	rel     Release
+	files   map[string]string
+	fetches int
+}
+
+func (s *fakeSource) publish(rel Release, files map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rel, s.files = rel, files
+}
+
+func (s *fakeSource) Version(ctx context.Context) (Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rel.Version == "" {
+		return Release{}, ErrRemoteVersionNotFound
+	}
+	return s.rel, nil
+}
+
+func (s *fakeSource) Fetch(ctx context.Context, rel Release, dest string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetches++
+	return writeTree(dest, s.files)
+}
+
+// writeTree creates dir holding files, a map of slash-separated relative
+// path -> content.
+func writeTree(dir string, files map[string]string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p, []byte(content), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// testConfig returns a Config keeping everything below root that runs
+// `sh run.sh` inside the local tree.
+func testConfig(root string) Config {
+	return Config{
+		Source:            "fake",
+		Subdir:            "im_main/instance_manager",
+		LocalDir:          filepath.Join(root, "instance_manager"),
+		VersionFile:       filepath.Join(root, ".current_version"),
+		RefFile:           filepath.Join(root, ".current_ref"),
+		RemoteVersionPath: "im_main/.current_version",
+		RunCommand:        []string{"sh", "run.sh"},
+		VersionsDir:       filepath.Join(root, ".versions"),
+		KeepVersions:      2,
+		StateFile:         filepath.Join(root, ".update_state.json"),
+		StopTimeout:       5 * time.Second,
+		RestartBackoff:    10 * time.Millisecond,
+		MaxRestartBackoff: 40 * time.Millisecond,
+	}
+}
+
+// installLocal puts files into LocalDir as version.
+func installLocal(t *testing.T, cfg Config, version string, files map[string]string) {
+	t.Helper()
+	if err := writeTree(cfg.LocalDir, files); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cfg.VersionFile, []byte(version), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readLines returns the lines of the file at p, none if it doesn't exist.
+func readLines(p string) []string {
+	b, _ := os.ReadFile(p)
+	return strings.Fields(string(b))
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startSupervise runs supervise in the background. The returned function
+// cancels it and waits for it to return.
+func startSupervise(t *testing.T, u *Updater, updated bool) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- u.supervise(ctx, updated) }()
+	return func() {
+		t.Helper()
+		cancel()
+		select {
+		case err := <-result:
+			if err != nil {
+				t.Errorf("supervise = %v", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("supervise didn't return after cancel")
+		}
+	}
+}
+
+func TestSuperviseRestartsCrashedChild(t *testing.T) {
+	root := t.TempDir()
+	cfg := testConfig(root)
+	runs := filepath.Join(root, "runs")
+	installLocal(t, cfg, "v1", map[string]string{"run.sh": "echo run >> " + runs + "\nexit 1\n"})
+
+	stop := startSupervise(t, New(cfg), false)
+	waitFor(t, "three runs", func() bool { return len(readLines(runs)) >= 3 })
+	stop()
+}
+
+func TestNextBackoff(t *testing.T) {
+	backoff := 10 * time.Millisecond
+	var got []time.Duration
+	for i := 0; i < 4; i++ {
+		backoff = nextBackoff(backoff, 50*time.Millisecond)
+		got = append(got, backoff)
+	}
+	want := []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("backoffs = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSuperviseStopsChildOnCancel(t *testing.T) {
+	root := t.TempDir()
+	cfg := testConfig(root)
+	events := filepath.Join(root, "events")
+	installLocal(t, cfg, "v1", map[string]string{"run.sh": "trap 'echo interrupted >> " + events + "; exit 0' INT\n" +
+		"echo started >> " + events + "\nwhile true; do sleep 0.05; done\n"})
+
+	stop := startSupervise(t, New(cfg), false)
+	waitFor(t, "the child to start", func() bool { return len(readLines(events)) == 1 })
+	stop()
+	if got := strings.Join(readLines(events), " "); got != "started interrupted" {
+		t.Errorf("events = %q, want the child interrupted once", got)
+	}
+}
+
+func TestSupervisePollsAndInstalls(t *testing.T) {
+	root := t.TempDir()
+	cfg := testConfig(root)
+	cfg.PollInterval = 20 * time.Millisecond
+	runs := filepath.Join(root, "runs")
+	script := func(version string) string {
+		return "echo " + version + " >> " + runs + "\nwhile true; do sleep 0.05; done\n"
+	}
+	installLocal(t, cfg, "v1", map[string]string{"run.sh": script("v1")})
+
+	src := &fakeSource{}
+	src.publish(Release{Version: "v1"}, nil)
+	u := New(cfg)
+	u.SetSource(src)
+
+	stop := startSupervise(t, u, false)
+	waitFor(t, "v1 to run", func() bool { return len(readLines(runs)) == 1 })
+
+	src.publish(Release{Version: "v2", Ref: "canary"}, map[string]string{"run.sh": script("v2")})
+	waitFor(t, "v2 to run", func() bool { return len(readLines(runs)) == 2 })
+	stop()
+
+	if got := strings.Join(readLines(runs), " "); got != "v1 v2" {
+		t.Errorf("runs = %q, want v1 then v2", got)
+	}
+	if v, _ := u.readLocalVersion(); v != "v2" || u.readLocalRef() != "canary" {
+		t.Errorf("local version = %q (%q), want v2 (canary)", v, u.readLocalRef())
+	}
+	if _, err := os.Stat(filepath.Join(u.versionDir("v1"), "run.sh")); err != nil {
+		t.Errorf("v1 wasn't kept for rollback: %v", err)
+	}
+	if src.fetches != 1 {
+		t.Errorf("fetched %d times, want once", src.fetches)
+	}
+}
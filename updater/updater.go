@@ -0,0 +1,366 @@
+// Package updater keeps a subtree of the ServerNet repository up to date on
+// disk and runs it. Both bootstrappers (server_main and im_main) are thin
+// wrappers around this package that only differ in their Config.
+package updater
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const httpTimeout = 60 * time.Second
+
+var (
+	ErrRemoteVersionNotFound = errors.New("remote version file not found")
+	ErrZipballNotFound       = errors.New("zipball not found (404) — repo may be private or removed")
+	// GitHub refuses requests until the rate limit resets, see RateLimitError
+	ErrRateLimited = errors.New("github rate limit exceeded")
+	// the download doesn't match its manifest or the signature is invalid
+	ErrVerification = errors.New("update verification failed")
+	// an archive entry would escape the destination or exceed the size limits
+	ErrUnsafeArchive = errors.New("unsafe archive")
+)
+
+// Config describes which subtree to watch and how to run it.
+type Config struct {
+	// GitHub repository owner and name.
+	Owner string
+	Repo  string
+	// where updates come from, see NewSource (empty = the GitHub repo above)
+	Source string
+	// branch, tag pattern or release to follow, see ParseChannel
+	Channel string
+	// file holding a GitHub token (empty = $GITHUB_TOKEN, if set), and the
+	// file caching API answers for conditional requests
+	TokenFile   string
+	GitHubCache string
+	// path inside the repo / zip to the subtree we care about
+	Subdir string
+	// local directory name to place the subtree into
+	LocalDir string
+	// local file holding the version that is currently on disk, and the
+	// branch or tag it came from (empty RefFile = don't record it)
+	VersionFile string
+	RefFile     string
+	// path inside the repo to the published version file
+	RemoteVersionPath string
+	// sha256sum manifest and its detached ed25519 signature, both relative
+	// to the subtree; downloads are only installed if they match (empty
+	// ManifestFile disables the check, empty PublicKey skips the signature)
+	ManifestFile  string
+	SignatureFile string
+	PublicKey     string
+	// command (and arguments) run inside LocalDir; when empty the tree is
+	// built with `go build` into BinDir and the binary is run instead
+	RunCommand []string
+	// directory holding one compiled binary per version
+	BinDir string
+	// directory holding the trees of previous versions, and how many to keep
+	VersionsDir  string
+	KeepVersions int
+	// file recording the previous version and the last update outcome
+	StateFile string
+	// URL that must answer 2xx within HealthTimeout after an update, or the
+	// previous version is restored (empty disables the check)
+	HealthURL     string
+	HealthTimeout time.Duration
+
+	// how often to check for a new version while running (0 disables polling)
+	PollInterval time.Duration
+	// how long the managed process gets to exit after SIGINT before it is killed
+	StopTimeout time.Duration
+	// delay before restarting a crashed process, doubled up to MaxRestartBackoff
+	RestartBackoff    time.Duration
+	MaxRestartBackoff time.Duration
+}
+
+// BindFlags registers command line flags for every Config field on fs,
+// using the current values of cfg as defaults.
+func BindFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Owner, "owner", cfg.Owner, "GitHub repository owner")
+	fs.StringVar(&cfg.Repo, "repo", cfg.Repo, "GitHub repository name")
+	fs.StringVar(&cfg.Source, "source", cfg.Source, "update source: HTTP(S) archive URL, local path, or git remote (default: the GitHub repository)")
+	fs.StringVar(&cfg.Channel, "channel", cfg.Channel, "branch, tag pattern (tag:im-v*) or \"release\" to follow (default: the default branch)")
+	fs.StringVar(&cfg.TokenFile, "token-file", cfg.TokenFile, "file holding a GitHub token (default: $GITHUB_TOKEN)")
+	fs.StringVar(&cfg.GitHubCache, "github-cache", cfg.GitHubCache, "file caching GitHub API answers across restarts")
+	fs.StringVar(&cfg.Subdir, "subdir", cfg.Subdir, "path of the watched subtree inside the repository")
+	fs.StringVar(&cfg.LocalDir, "local-dir", cfg.LocalDir, "local directory the subtree is placed into")
+	fs.StringVar(&cfg.VersionFile, "version-file", cfg.VersionFile, "local version file")
+	fs.StringVar(&cfg.RefFile, "ref-file", cfg.RefFile, "local file recording the branch or tag of the local version")
+	fs.StringVar(&cfg.RemoteVersionPath, "remote-version", cfg.RemoteVersionPath, "path of the version file inside the repository")
+	fs.StringVar(&cfg.ManifestFile, "manifest", cfg.ManifestFile, "SHA-256 manifest inside the subtree (empty disables verification)")
+	fs.StringVar(&cfg.SignatureFile, "signature", cfg.SignatureFile, "ed25519 signature of the manifest inside the subtree")
+	fs.StringVar(&cfg.PublicKey, "public-key", cfg.PublicKey, "base64 ed25519 public key the manifest must be signed with (default: $UPDATE_PUBLIC_KEY)")
+	fs.Func("run", "command run inside the local directory instead of the compiled binary", func(s string) error {
+		cfg.RunCommand = strings.Fields(s)
+		return nil
+	})
+	fs.StringVar(&cfg.BinDir, "bin-dir", cfg.BinDir, "directory for compiled binaries")
+	fs.StringVar(&cfg.VersionsDir, "versions-dir", cfg.VersionsDir, "directory for the trees of previous versions")
+	fs.IntVar(&cfg.KeepVersions, "keep-versions", cfg.KeepVersions, "number of previous versions kept on disk")
+	fs.StringVar(&cfg.StateFile, "state-file", cfg.StateFile, "file recording the last update result")
+	fs.StringVar(&cfg.HealthURL, "health-url", cfg.HealthURL, "URL checked after an update (empty disables rollback)")
+	fs.DurationVar(&cfg.HealthTimeout, "health-timeout", cfg.HealthTimeout, "time a new version gets to answer the health URL")
+	fs.DurationVar(&cfg.PollInterval, "poll", cfg.PollInterval, "interval between update checks while running (0 disables)")
+	fs.DurationVar(&cfg.StopTimeout, "stop-timeout", cfg.StopTimeout, "time to wait after SIGINT before killing the managed process")
+	fs.DurationVar(&cfg.RestartBackoff, "restart-backoff", cfg.RestartBackoff, "initial delay before restarting a crashed process")
+	fs.DurationVar(&cfg.MaxRestartBackoff, "max-restart-backoff", cfg.MaxRestartBackoff, "maximum delay before restarting a crashed process")
+}
+
+// Validate reports the first missing required field.
+func (c Config) Validate() error {
+	switch {
+	case c.Source == "" && (c.Owner == "" || c.Repo == ""):
+		return errors.New("owner and repo are required without a source")
+	case c.Subdir == "":
+		return errors.New("subdir is required")
+	case c.LocalDir == "":
+		return errors.New("local dir is required")
+	case c.VersionFile == "":
+		return errors.New("version file is required")
+	case c.RemoteVersionPath == "":
+		return errors.New("remote version path is required")
+	case c.PublicKey != "" && (c.ManifestFile == "" || c.SignatureFile == ""):
+		return errors.New("a public key needs a manifest and a signature file")
+	case len(c.RunCommand) == 0 && c.BinDir == "":
+		return errors.New("either a run command or a bin dir is required")
+	case c.PollInterval < 0:
+		return errors.New("poll interval must not be negative")
+	case c.StopTimeout <= 0:
+		return errors.New("stop timeout must be positive")
+	case c.RestartBackoff <= 0 || c.MaxRestartBackoff < c.RestartBackoff:
+		return errors.New("restart backoff must be positive and not exceed the maximum")
+	case c.VersionsDir == "" || c.StateFile == "":
+		return errors.New("versions dir and state file are required")
+	case c.KeepVersions < 1:
+		return errors.New("at least one previous version must be kept")
+	case c.HealthURL != "" && c.HealthTimeout <= 0:
+		return errors.New("health timeout must be positive")
+	}
+	_, err := ParseChannel(c.Channel)
+	return err
+}
+
+// Updater checks for, downloads and runs new versions of the watched subtree.
+type Updater struct {
+	cfg Config
+	src UpdateSource
+	// binary path -> build error, so a broken version is only compiled once
+	failedBuilds map[string]error
+}
+
+func New(cfg Config) *Updater {
+	return &Updater{cfg: cfg, failedBuilds: make(map[string]error)}
+}
+
+// SetSource overrides the UpdateSource built from Config.Source.
+func (u *Updater) SetSource(src UpdateSource) {
+	u.src = src
+}
+
+// Run checks for an update, installs it if needed and then supervises the
+// local subtree until the bootstrapper receives SIGINT or SIGTERM.
+func (u *Updater) Run() error {
+	if err := u.init(); err != nil {
+		return err
+	}
+	log.Printf("Updating %s from %v", u.cfg.LocalDir, u.src)
+	updated, err := u.checkAndUpdate()
+	if err != nil {
+		return err
+	}
+	return u.superviseUntilSignal(updated)
+}
+
+// RunLocal supervises the local subtree without checking for an update
+// first. Polling still happens if PollInterval is set.
+func (u *Updater) RunLocal() error {
+	if err := u.init(); err != nil {
+		return err
+	}
+	return u.superviseUntilSignal(false)
+}
+
+// Check reports whether the source publishes a version that would be
+// installed by Update.
+func (u *Updater) Check() (Release, bool, error) {
+	if err := u.init(); err != nil {
+		return Release{}, false, err
+	}
+	return u.pending()
+}
+
+// Update installs the remote version if it differs from the local one,
+// without running it. Unlike Run, failing to reach the source is an error.
+func (u *Updater) Update() (bool, error) {
+	remote, ok, err := u.Check()
+	if err != nil || !ok {
+		return false, err
+	}
+	log.Printf("Installing version %s of %s...", remote.Version, u.cfg.LocalDir)
+	if err := u.update(remote); err != nil {
+		return false, fmt.Errorf("update failed: %w", err)
+	}
+	return true, nil
+}
+
+// Rollback restores the previous version. The current one is marked as
+// rejected so that it isn't installed again by the next update check.
+func (u *Updater) Rollback() error {
+	if err := u.cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	return u.rollback("rolled back manually")
+}
+
+// init validates the config and builds the update source unless SetSource
+// was called.
+func (u *Updater) init() error {
+	if err := u.cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if u.src == nil {
+		src, err := NewSource(u.cfg)
+		if err != nil {
+			return err
+		}
+		u.src = src
+	}
+	return nil
+}
+
+func (u *Updater) superviseUntilSignal(updated bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return u.supervise(ctx, updated)
+}
+
+// pending fetches the remote version and reports whether it should be
+// installed: it differs from the local one and wasn't rolled back before.
+func (u *Updater) pending() (Release, bool, error) {
+	remote, err := u.src.Version(context.Background())
+	if err != nil {
+		return Release{}, false, err
+	}
+	localVersion, _ := u.readLocalVersion()
+	if localVersion == "" {
+		return remote, true, nil
+	}
+	if localVersion == remote.Version {
+		return remote, false, nil
+	}
+	_, rejected := u.loadState().Rejected[remote.Version]
+	return remote, !rejected, nil
+}
+
+// checkAndUpdate installs the remote version if it differs from the local
+// one and reports whether it did. Failing to reach the remote is not an
+// error; the local tree is used.
+func (u *Updater) checkAndUpdate() (bool, error) {
+	localVersion, _ := u.readLocalVersion()
+	log.Printf("Local version: %s", localVersion)
+
+	remote, ok, err := u.pending()
+	if err != nil {
+		log.Printf("Warning: could not fetch remote version: %v", err)
+		return false, nil
+	}
+	remoteVersion := remote.Version
+
+	if !ok {
+		if reason, rejected := u.loadState().Rejected[remoteVersion]; rejected && localVersion != remoteVersion {
+			log.Printf("Remote version %s was rolled back before (%s). Staying on %s.", remoteVersion, reason, localVersion)
+			return false, nil
+		}
+		log.Printf("Local: %s Remote: %s", localVersion, remoteVersion)
+		log.Printf("No update detected. Running local %s...", u.cfg.LocalDir)
+		return false, nil
+	}
+
+	log.Printf("Update detected (or local version missing). Downloading new %s...", u.cfg.LocalDir)
+
+	if err := u.update(remote); err != nil {
+		return false, fmt.Errorf("update failed: %w", err)
+	}
+	return true, nil
+}
+
+// update replaces LocalDir with the subtree of rel and records it as the
+// local version.
+func (u *Updater) update(rel Release) error {
+	staged, cleanup, err := u.download(rel)
+	if err != nil {
+		localVersion, _ := u.readLocalVersion()
+		u.recordResult(localVersion, rel.Version, "failed", err.Error())
+		return err
+	}
+	defer cleanup()
+	return u.install(staged, rel)
+}
+
+// download fetches the subtree of rel into a temporary directory, verifies
+// it against its manifest and returns its path. The caller must call cleanup
+// once the tree has been moved away or is no longer needed.
+func (u *Updater) download(rel Release) (string, func(), error) {
+	tempDir, err := os.MkdirTemp("", "repo-extract-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { _ = os.RemoveAll(tempDir) }
+	dest := filepath.Join(tempDir, filepath.Base(u.cfg.LocalDir))
+
+	if err := u.src.Fetch(context.Background(), rel, dest); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if err := u.verifyTree(dest); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return dest, cleanup, nil
+}
+
+func (u *Updater) readLocalVersion() (string, error) {
+	b, err := os.ReadFile(u.cfg.VersionFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (u *Updater) writeLocalVersion(content string) error {
+	return os.WriteFile(u.cfg.VersionFile, []byte(strings.TrimSpace(content)), 0644)
+}
+
+func (u *Updater) readLocalRef() string {
+	if u.cfg.RefFile == "" {
+		return ""
+	}
+	b, _ := os.ReadFile(u.cfg.RefFile)
+	return strings.TrimSpace(string(b))
+}
+
+// writeLocalRef records the branch or tag of the local version next to the
+// version file. An empty ref (the default branch) removes the file.
+func (u *Updater) writeLocalRef(ref string) error {
+	if u.cfg.RefFile == "" {
+		return nil
+	}
+	if ref == "" {
+		err := os.Remove(u.cfg.RefFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(u.cfg.RefFile, []byte(ref), 0644)
+}
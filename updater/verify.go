@@ -0,0 +1,193 @@
+package updater
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// verifyTree checks the staged tree against the SHA-256 manifest published
+// inside it (the format written by `sha256sum`). Every file in the tree must
+// be listed with a matching digest, except the manifest and its signature.
+// When a public key is configured the manifest must carry a valid ed25519
+// signature; a signed manifest without a pinned key is only warned about.
+// Without a configured ManifestFile nothing is checked.
+func (u *Updater) verifyTree(dir string) error {
+	if u.cfg.ManifestFile == "" {
+		return nil
+	}
+
+	manifest, err := os.ReadFile(filepath.Join(dir, u.cfg.ManifestFile))
+	if err != nil {
+		return fmt.Errorf("%w: manifest %s missing: %v", ErrVerification, u.cfg.ManifestFile, err)
+	}
+	if u.cfg.PublicKey != "" {
+		if err := u.verifySignature(dir, manifest); err != nil {
+			return err
+		}
+	} else if u.cfg.SignatureFile != "" {
+		if _, err := os.Stat(filepath.Join(dir, u.cfg.SignatureFile)); err == nil {
+			log.Printf("Warning: %s is signed but no public key is pinned (-public-key or $UPDATE_PUBLIC_KEY), the signature isn't checked", u.cfg.ManifestFile)
+		}
+	}
+
+	want, err := parseManifest(manifest)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrVerification, u.cfg.ManifestFile, err)
+	}
+
+	skip := map[string]bool{filepath.ToSlash(u.cfg.ManifestFile): true}
+	if u.cfg.SignatureFile != "" {
+		skip[filepath.ToSlash(u.cfg.SignatureFile)] = true
+	}
+
+	var mismatched, unlisted []string
+	seen := make(map[string]bool, len(want))
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if skip[rel] {
+			return nil
+		}
+
+		sum, ok := want[rel]
+		if !ok {
+			unlisted = append(unlisted, rel)
+			return nil
+		}
+		seen[rel] = true
+		got, err := fileSHA256(path)
+		if err != nil {
+			return err
+		}
+		if got != sum {
+			mismatched = append(mismatched, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+
+	var missing []string
+	for rel := range want {
+		if !seen[rel] {
+			missing = append(missing, rel)
+		}
+	}
+
+	if len(mismatched)+len(unlisted)+len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	var problems []string
+	if len(mismatched) > 0 {
+		problems = append(problems, "checksum mismatch: "+strings.Join(mismatched, ", "))
+	}
+	if len(unlisted) > 0 {
+		problems = append(problems, "not in manifest: "+strings.Join(unlisted, ", "))
+	}
+	if len(missing) > 0 {
+		problems = append(problems, "missing from download: "+strings.Join(missing, ", "))
+	}
+	return fmt.Errorf("%w: %s", ErrVerification, strings.Join(problems, "; "))
+}
+
+// verifySignature checks the detached ed25519 signature over the manifest
+// (raw 64 bytes or base64, as written by `openssl pkeyutl -sign -rawin`).
+func (u *Updater) verifySignature(dir string, manifest []byte) error {
+	if u.cfg.SignatureFile == "" {
+		return fmt.Errorf("%w: a public key is configured but no signature file", ErrVerification)
+	}
+	pub, err := parsePublicKey(u.cfg.PublicKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+
+	sig, err := os.ReadFile(filepath.Join(dir, u.cfg.SignatureFile))
+	if err != nil {
+		return fmt.Errorf("%w: signature %s missing: %v", ErrVerification, u.cfg.SignatureFile, err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil || len(decoded) != ed25519.SignatureSize {
+			return fmt.Errorf("%w: %s is not an ed25519 signature", ErrVerification, u.cfg.SignatureFile)
+		}
+		sig = decoded
+	}
+
+	if !ed25519.Verify(pub, manifest, sig) {
+		return fmt.Errorf("%w: signature of %s does not match the pinned public key", ErrVerification, u.cfg.ManifestFile)
+	}
+	return nil
+}
+
+// parsePublicKey accepts a base64 or hex encoded raw ed25519 public key.
+func parsePublicKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		b, err = hex.DecodeString(s)
+	}
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, errors.New("public key must be a base64 or hex encoded 32-byte ed25519 key")
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// parseManifest reads `sha256sum` output into a map of slash-separated
+// relative path -> lowercase hex digest.
+func parseManifest(b []byte) (map[string]string, error) {
+	sums := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("line %d: expected \"<sha256>  <path>\"", n)
+		}
+		if _, err := hex.DecodeString(sum); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		// sha256sum marks binary mode with a leading '*'
+		name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*")
+		name = strings.TrimPrefix(name, "./")
+		sums[name] = strings.ToLower(sum)
+	}
+	return sums, sc.Err()
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
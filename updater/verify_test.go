@@ -0,0 +1,125 @@
+package updater
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// manifestOf returns the sha256sum manifest of files.
+func manifestOf(files map[string]string) string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		sum := sha256.Sum256([]byte(files[name]))
+		fmt.Fprintf(&b, "%s  ./%s\n", hex.EncodeToString(sum[:]), name)
+	}
+	return b.String()
+}
+
+func TestVerifyTree(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"main.go":           "package main\n",
+		"plugins/Lunex.jar": "jar",
+	}
+	manifest := manifestOf(files)
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(manifest)))
+
+	cases := []struct {
+		name      string
+		publicKey ed25519.PublicKey
+		change    func(tree map[string]string)
+		problem   string // empty = verifies
+	}{
+		{name: "good manifest"},
+		{name: "checksum mismatch", change: func(tree map[string]string) { tree["main.go"] = "package evil\n" }, problem: "checksum mismatch: main.go"},
+		{name: "unlisted file", change: func(tree map[string]string) { tree["backdoor.sh"] = "" }, problem: "not in manifest: backdoor.sh"},
+		{name: "missing file", change: func(tree map[string]string) { delete(tree, "plugins/Lunex.jar") }, problem: "missing from download: plugins/Lunex.jar"},
+		{name: "valid signature", publicKey: pub},
+		{name: "tampered signature", publicKey: pub, change: func(tree map[string]string) {
+			sig, _ := base64.StdEncoding.DecodeString(tree["SHA256SUMS.sig"])
+			sig[0] ^= 0xff
+			tree["SHA256SUMS.sig"] = base64.StdEncoding.EncodeToString(sig)
+		}, problem: "does not match the pinned public key"},
+		{name: "tampered manifest", publicKey: pub, change: func(tree map[string]string) {
+			tree["SHA256SUMS"] += strings.Repeat("0", 64) + "  ./extra\n"
+		}, problem: "does not match the pinned public key"},
+		{name: "wrong public key", publicKey: otherPub, problem: "does not match the pinned public key"},
+		{name: "missing signature", publicKey: pub, change: func(tree map[string]string) { delete(tree, "SHA256SUMS.sig") }, problem: "signature SHA256SUMS.sig missing"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tree := map[string]string{"SHA256SUMS": manifest, "SHA256SUMS.sig": signature}
+			for name, content := range files {
+				tree[name] = content
+			}
+			if c.change != nil {
+				c.change(tree)
+			}
+			dir := filepath.Join(t.TempDir(), "instance_manager")
+			if err := writeTree(dir, tree); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg := Config{ManifestFile: "SHA256SUMS", SignatureFile: "SHA256SUMS.sig"}
+			if c.publicKey != nil {
+				cfg.PublicKey = base64.StdEncoding.EncodeToString(c.publicKey)
+			}
+			err := New(cfg).verifyTree(dir)
+			switch {
+			case c.problem == "" && err != nil:
+				t.Errorf("verifyTree = %v, want success", err)
+			case c.problem != "" && (!errors.Is(err, ErrVerification) || !strings.Contains(err.Error(), c.problem)):
+				t.Errorf("verifyTree = %v, want %q", err, c.problem)
+			}
+		})
+	}
+}
+
+func TestVerifyTreeWithoutManifest(t *testing.T) {
+	dir := t.TempDir()
+	if err := writeTree(dir, map[string]string{"main.go": "package main\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(Config{}).verifyTree(dir); err != nil {
+		t.Errorf("verifyTree without ManifestFile = %v", err)
+	}
+	err := New(Config{ManifestFile: "SHA256SUMS"}).verifyTree(dir)
+	if !errors.Is(err, ErrVerification) {
+		t.Errorf("verifyTree without the manifest on disk = %v", err)
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{base64.StdEncoding.EncodeToString(pub), hex.EncodeToString(pub) + "\n"} {
+		if got, err := parsePublicKey(s); err != nil || !got.Equal(pub) {
+			t.Errorf("parsePublicKey(%q) = %x, %v", s, got, err)
+		}
+	}
+	if _, err := parsePublicKey("c2hvcnQ="); err == nil {
+		t.Error("parsePublicKey accepted a short key")
+	}
+}
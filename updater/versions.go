@@ -0,0 +1,169 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// versionDir is where the tree of version is kept once it is replaced.
+func (u *Updater) versionDir(version string) string {
+	if version == "" {
+		version = "unversioned"
+	}
+	return filepath.Join(u.cfg.VersionsDir, sanitizeVersion(version))
+}
+
+// install moves the staged tree into LocalDir and records rel as the local
+// version. The tree it replaces is kept in VersionsDir so that it can be
+// restored by rollback.
+func (u *Updater) install(staged string, rel Release) error {
+	oldVersion, _ := u.readLocalVersion()
+	oldRef := u.readLocalRef()
+
+	if err := u.replaceLocalDir(staged, oldVersion); err != nil {
+		u.recordResult(oldVersion, rel.Version, "failed", err.Error())
+		return err
+	}
+	if err := u.writeLocalVersion(rel.Version); err != nil {
+		log.Printf("Warning: failed to write local version file: %v", err)
+	}
+	if err := u.writeLocalRef(rel.Ref); err != nil {
+		log.Printf("Warning: failed to write local ref file: %v", err)
+	}
+
+	st := u.loadState()
+	st.Previous, st.PreviousRef = oldVersion, oldRef
+	st.LastUpdate = &updateResult{From: oldVersion, To: rel.Version, Ref: rel.Ref, Time: time.Now().UTC(), Result: "installed"}
+	if err := u.saveState(st); err != nil {
+		log.Printf("Warning: failed to write %s: %v", u.cfg.StateFile, err)
+	}
+	return nil
+}
+
+// replaceLocalDir moves newPath into LocalDir. The old tree is moved into
+// VersionsDir under oldVersion and only the newest KeepVersions are kept.
+func (u *Updater) replaceLocalDir(newPath, oldVersion string) error {
+	local := u.cfg.LocalDir
+	if _, err := os.Stat(local); err == nil {
+		if err := os.MkdirAll(u.cfg.VersionsDir, 0755); err != nil {
+			return err
+		}
+		keep := u.versionDir(oldVersion)
+		_ = os.RemoveAll(keep)
+		if err := moveDirAtomic(local, keep); err != nil {
+			return fmt.Errorf("failed to move old %s to %s: %w", local, keep, err)
+		}
+		// renaming doesn't touch the mtime, which pruning sorts by
+		now := time.Now()
+		_ = os.Chtimes(keep, now, now)
+	}
+
+	if err := moveDirAtomic(newPath, local); err != nil {
+		return fmt.Errorf("failed to move new %s into place: %w", local, err)
+	}
+	u.pruneVersions()
+	return nil
+}
+
+// pruneVersions removes all but the newest KeepVersions trees.
+func (u *Updater) pruneVersions() {
+	entries, err := os.ReadDir(u.cfg.VersionsDir)
+	if err != nil {
+		return
+	}
+	type kept struct {
+		path    string
+		modTime time.Time
+	}
+	var dirs []kept
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		dirs = append(dirs, kept{filepath.Join(u.cfg.VersionsDir, e.Name()), info.ModTime()})
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].modTime.After(dirs[j].modTime) })
+
+	for i := u.cfg.KeepVersions; i < len(dirs); i++ {
+		if err := os.RemoveAll(dirs[i].path); err != nil {
+			log.Printf("Warning: failed to remove old version %s: %v", dirs[i].path, err)
+			continue
+		}
+		log.Printf("Removed old version %s", dirs[i].path)
+	}
+}
+
+// rollback restores the previous version recorded in the state file. The
+// current version is remembered as rejected together with reason, so that
+// polling doesn't install it again.
+func (u *Updater) rollback(reason string) error {
+	st := u.loadState()
+	current, _ := u.readLocalVersion()
+	if st.Previous == "" {
+		return errors.New("no previous version recorded")
+	}
+	prevDir := u.versionDir(st.Previous)
+	if _, err := os.Stat(prevDir); err != nil {
+		return fmt.Errorf("previous version %s is no longer on disk: %w", st.Previous, err)
+	}
+
+	log.Printf("Rolling back %s from %s to %s: %s", u.cfg.LocalDir, current, st.Previous, reason)
+	if err := os.RemoveAll(u.cfg.LocalDir); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", u.cfg.LocalDir, err)
+	}
+	if err := moveDirAtomic(prevDir, u.cfg.LocalDir); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", prevDir, err)
+	}
+	if err := u.writeLocalVersion(st.Previous); err != nil {
+		log.Printf("Warning: failed to write local version file: %v", err)
+	}
+	if err := u.writeLocalRef(st.PreviousRef); err != nil {
+		log.Printf("Warning: failed to write local ref file: %v", err)
+	}
+
+	if current != "" {
+		st.Rejected[current] = reason
+	}
+	st.LastUpdate = &updateResult{From: current, To: st.Previous, Ref: st.PreviousRef, Time: time.Now().UTC(), Result: "rolled_back", Reason: reason}
+	st.Previous, st.PreviousRef = "", ""
+	return u.saveState(st)
+}
+
+// healthCheck polls HealthURL until it answers with a 2xx status or the
+// HealthTimeout passes.
+func (u *Updater) healthCheck(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, u.cfg.HealthTimeout)
+	defer cancel()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	var lastErr error
+	for {
+		req, _ := http.NewRequestWithContext(ctx, "GET", u.cfg.HealthURL, nil)
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("%s returned %s", u.cfg.HealthURL, resp.Status)
+		}
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("no healthy answer from %s within %s: %v", u.cfg.HealthURL, u.cfg.HealthTimeout, lastErr)
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
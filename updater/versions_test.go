@@ -0,0 +1,109 @@
+package updater
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailedHealthCheckRollsBack(t *testing.T) {
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+
+	root := t.TempDir()
+	cfg := testConfig(root)
+	cfg.HealthURL = unhealthy.URL
+	cfg.HealthTimeout = 100 * time.Millisecond
+	installLocal(t, cfg, "v1", map[string]string{"run.sh": "echo v1\n"})
+
+	src := &fakeSource{}
+	src.publish(Release{Version: "v2"}, map[string]string{"run.sh": "echo v2\n"})
+	u := New(cfg)
+	u.SetSource(src)
+
+	if updated, err := u.Update(); err != nil || !updated {
+		t.Fatalf("Update = %v, %v", updated, err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(cfg.LocalDir, "run.sh")); string(b) != "echo v2\n" {
+		t.Fatalf("run.sh = %q after installing v2", b)
+	}
+	if st := u.loadState(); st.Previous != "v1" || st.LastUpdate.Result != "installed" {
+		t.Errorf("state = %+v, want v1 kept as previous", st)
+	}
+
+	err := u.healthCheck(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("healthCheck = %v, want a 503 failure", err)
+	}
+	if err := u.rollback(err.Error()); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+
+	if v, _ := u.readLocalVersion(); v != "v1" {
+		t.Errorf("local version = %q, want v1", v)
+	}
+	if b, _ := os.ReadFile(filepath.Join(cfg.LocalDir, "run.sh")); string(b) != "echo v1\n" {
+		t.Errorf("run.sh = %q after the rollback", b)
+	}
+	st := u.loadState()
+	if _, rejected := st.Rejected["v2"]; !rejected || st.Previous != "" || st.LastUpdate.Result != "rolled_back" {
+		t.Errorf("state = %+v, want v2 rejected", st)
+	}
+
+	// the rejected version isn't installed again
+	if rel, ok, err := u.pending(); err != nil || ok {
+		t.Errorf("pending = %+v, %v, %v; want v2 skipped", rel, ok, err)
+	}
+	if updated, err := u.Update(); err != nil || updated {
+		t.Errorf("Update = %v, %v; want nothing installed", updated, err)
+	}
+	if src.fetches != 1 {
+		t.Errorf("fetched %d times, want once", src.fetches)
+	}
+
+	// a second rollback has nothing to go back to
+	if err := u.rollback("again"); err == nil {
+		t.Error("rollback without a previous version succeeded")
+	}
+}
+
+func TestPruneKeepsVersions(t *testing.T) {
+	root := t.TempDir()
+	cfg := testConfig(root)
+	cfg.KeepVersions = 2
+	installLocal(t, cfg, "v1", map[string]string{"run.sh": "echo v1\n"})
+	u := New(cfg)
+
+	for _, version := range []string{"v2", "v3", "v4", "v5"} {
+		staged := filepath.Join(t.TempDir(), "instance_manager")
+		if err := writeTree(staged, map[string]string{"run.sh": "echo " + version + "\n"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := u.install(staged, Release{Version: version}); err != nil {
+			t.Fatalf("installing %s: %v", version, err)
+		}
+		// pruning goes by mtime, keep them apart
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	entries, err := os.ReadDir(cfg.VersionsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, e := range entries {
+		kept = append(kept, e.Name())
+	}
+	sort.Strings(kept)
+	if got := strings.Join(kept, " "); got != "v3 v4" {
+		t.Errorf("kept versions = %q, want the newest %d: v3 v4", got, cfg.KeepVersions)
+	}
+}